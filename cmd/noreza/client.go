@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/caedis/noreza/internal/input"
+	"github.com/caedis/noreza/internal/mapping"
+	"github.com/caedis/noreza/internal/shared/paths"
+	"github.com/caedis/noreza/internal/web"
+)
+
+var targetDevice = flag.String("device", "", "serial or product id of the device a command applies to\nDefaults to the first device")
+
+const clientUsage = `Commands:
+  noreza devices [--json]
+Sent to the running daemon:
+  noreza profiles list
+  noreza profile activate <name>
+  noreza profile export <name>
+  noreza status
+  noreza bind <profile> button <number> [keys...]
+  noreza bind <profile> axis <index> <positive|negative> [keys...]
+  noreza bind <profile> hat <index> <up|down|left|right> [keys...]
+  noreza bind <profile> sector <number|ring> [keys...]
+  noreza bind <profile> chord <number> [keys...]
+Buttons and sectors are numbered from 1 as shown in the web interface, and
+chords from 1 in the order they're listed there. Axes and hats count from 0.
+Keys are names as shown in the web interface (KeyE, LClick, PadSouth, ...)
+or the kernel's KEY_* names (KEY_MICMUTE).
+Leaving them out clears the binding.`
+
+func init() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(out, "\n%s\n", clientUsage)
+	}
+}
+
+// Talks to the daemon's API over its Unix socket
+type client struct {
+	http *http.Client
+}
+
+func newClient() *client {
+	socketPath := paths.SocketPath()
+	return &client{http: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}}
+}
+
+// Sends a request and decodes the JSON response into out, if given
+func (c *client) do(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, "http://noreza"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if *targetDevice != "" {
+		req.Header.Set("X-Noreza-Device", *targetDevice)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach the daemon, is it running? (%w)", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s", apiErr.Error)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func runClient(args []string) error {
+	c := newClient()
+
+	switch {
+	case matchArgs(args, "devices"):
+		return c.listDevices(args[1:])
+	case matchArgs(args, "profiles", "list"):
+		return c.listProfiles()
+	case matchArgs(args, "profile", "activate") && len(args) == 3:
+		return c.do(http.MethodPost, "/api/v1/profiles/"+url.PathEscape(args[2])+"/activate", nil, nil)
+	case matchArgs(args, "profile", "export") && len(args) == 3:
+		return c.exportProfile(args[2])
+	case matchArgs(args, "status"):
+		return c.status()
+	case matchArgs(args, "bind") && len(args) >= 4:
+		return c.bind(args[1], args[2:])
+	}
+	return fmt.Errorf("unknown command %q\n%s", strings.Join(args, " "), clientUsage)
+}
+
+// Scans for devices directly, so it works without the daemon running. If it
+// is running, it's asked which devices it has grabbed.
+func (c *client) listDevices(args []string) error {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	fs.Parse(args)
+
+	devices, err := input.ListDevices()
+	if err != nil {
+		if len(devices) == 0 {
+			return fmt.Errorf("no Azeron devices found: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "some devices could not be opened: %s\n", err)
+	}
+
+	var running []web.DeviceInfo
+	if c.do(http.MethodGet, "/api/v1/devices", nil, &running) == nil {
+		for i := range devices {
+			for _, d := range running {
+				if d.Path == devices[i].Path {
+					devices[i].Grabbed = d.Grabbed
+				}
+			}
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(devices)
+	}
+
+	if len(devices) == 0 {
+		fmt.Println("No Azeron devices found")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tPRODUCT ID\tMODEL\tSERIAL\tGRABBED")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%s\t%#04x\t%s\t%s\t%t\n", d.Path, d.ProductID, d.Model, d.Serial, d.Grabbed)
+	}
+	return w.Flush()
+}
+
+func matchArgs(args []string, words ...string) bool {
+	if len(args) < len(words) {
+		return false
+	}
+	for i, word := range words {
+		if args[i] != word {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *client) listProfiles() error {
+	var profiles []struct {
+		Name   string `json:"name"`
+		Active bool   `json:"active"`
+		Pinned bool   `json:"pinned"`
+	}
+	if err := c.do(http.MethodGet, "/api/v1/profiles", nil, &profiles); err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		line := "  " + p.Name
+		if p.Active {
+			line = "* " + p.Name
+		}
+		if p.Pinned {
+			line += " (pinned)"
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func (c *client) exportProfile(name string) error {
+	var m json.RawMessage
+	if err := c.do(http.MethodGet, "/api/v1/profiles/"+url.PathEscape(name)+"/mapping", nil, &m); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, m, "", "\t"); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err := out.WriteTo(os.Stdout)
+	return err
+}
+
+func (c *client) status() error {
+	var devices []web.DeviceInfo
+	if err := c.do(http.MethodGet, "/api/v1/devices", nil, &devices); err != nil {
+		return err
+	}
+	for _, d := range devices {
+		state := "connected"
+		if !d.Connected {
+			state = "disconnected"
+		}
+		fmt.Printf("%s (%s): %s\n", d.Identifier, d.Model, state)
+		fmt.Printf("  active profile: %s\n", d.ActiveProfile)
+		if d.PinnedProfile != "" {
+			fmt.Printf("  pinned, auto switching paused\n")
+		}
+		fmt.Printf("  exclusive access: %t\n", d.Grabbed)
+	}
+	return nil
+}
+
+// args are the input type, its index (or ring for the outer ring of sectors
+// mode), a direction for axes and hats, then the keys to bind. Buttons,
+// sectors and chords are numbered from 1 like in the editor, axes and hats
+// from 0.
+func (c *client) bind(profile string, args []string) error {
+	keyType, rawIndex, rest := args[0], args[1], args[2:]
+	subKey := ""
+	if keyType == "sector" && rawIndex == "ring" {
+		rawIndex, subKey = "1", "ring"
+	}
+	index, err := strconv.ParseUint(rawIndex, 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid index %q", rawIndex)
+	}
+	if keyType == "button" || keyType == "sector" || keyType == "chord" {
+		if index == 0 {
+			return fmt.Errorf("%s numbers start at 1", keyType)
+		}
+		index--
+	}
+
+	path := fmt.Sprintf("/api/v1/profiles/%s/bindings/%s/%d", url.PathEscape(profile), url.PathEscape(keyType), index)
+	if keyType == "axis" || keyType == "hat" {
+		if len(rest) == 0 {
+			return fmt.Errorf("%s bindings need a direction", keyType)
+		}
+		subKey, rest = rest[0], rest[1:]
+	}
+	if subKey != "" {
+		path += "/" + url.PathEscape(subKey)
+	}
+
+	binding := mapping.Binding{Keys: []mapping.KeyMapping{}}
+	for _, name := range rest {
+		key, ok := mapping.ParseOutputKey(name)
+		if !ok {
+			return fmt.Errorf("unknown key %q", name)
+		}
+		binding.Keys = append(binding.Keys, key)
+	}
+	return c.do(http.MethodPatch, path, binding, nil)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -22,17 +24,65 @@ import (
 	"github.com/caedis/noreza/internal/web"
 )
 
-var inputSerial = flag.String("serial", "", "serial of target azeron device")
-var inputProductID = flag.Uint("product-id", 0, "product id of target azeron device\nPrefix with 0x\nOnly use if your device has no serial\nWill pull the first device found with product id")
+var inputSerials listFlag
+var inputProductIDs listFlag
 var port = flag.Int("port", 1337, "web server port")
 var quiet = flag.Bool("quiet", false, "disable logging")
 var wait = flag.Bool("wait", false, "wait for device to connect instead of exiting if not found")
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to `file`")
 var memprofile = flag.String("memprofile", "", "write memory profile to `file`")
 
+// A flag that can be repeated or given a comma separated list
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(value string) error {
+	for v := range strings.SplitSeq(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// Identifies one physical device, by serial or failing that product id
+type deviceSpec struct {
+	serial    string
+	productID uint16
+}
+
+func (d deviceSpec) identifier() string {
+	if d.serial != "" {
+		return d.serial
+	}
+	return strconv.Itoa(int(d.productID))
+}
+
+// Everything running for a single device
+type device struct {
+	spec   deviceSpec
+	store  *mapping.Store
+	reader *input.Reader
+	writer *output.Writer
+}
+
+func init() {
+	flag.Var(&inputSerials, "serial", "serial of target azeron device\nRepeat or comma separate to manage several devices")
+	flag.Var(&inputProductIDs, "product-id", "product id of target azeron device\nPrefix with 0x\nOnly use if your device has no serial\nWill pull the first device found with product id")
+}
+
 func main() {
 	flag.Parse()
 
+	if flag.NArg() > 0 {
+		if err := runClient(flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -49,90 +99,56 @@ func main() {
 		log.SetOutput(io.Discard)
 	}
 
-	if *inputSerial == "" && *inputProductID == 0 {
-		log.Fatal("No input device serial/product-id provided")
+	var specs []deviceSpec
+	for _, serial := range inputSerials {
+		specs = append(specs, deviceSpec{serial: serial})
 	}
-
-	log.Println("Connecting to device")
-	var devicePath string
-	var productID uint16
-	var err error
-	var wroteMessage bool
-	for {
-		devicePath, productID, err = input.GetDevicePath(*inputSerial, uint16(*inputProductID))
+	for _, raw := range inputProductIDs {
+		productID, err := strconv.ParseUint(raw, 0, 16)
 		if err != nil {
-			if !*wait {
-				log.Fatal(err.Error())
-				return
-			}
-			if !wroteMessage {
-				log.Println("Retrying every 2s for device to be connected")
-				wroteMessage = true
-			}
-			time.Sleep(2 * time.Second)
-			continue
+			log.Fatalf("invalid product id %q", raw)
 		}
-		break
+		specs = append(specs, deviceSpec{productID: uint16(productID)})
 	}
-	log.Println("Connected")
-
-	var deviceIdentifier string
-	if *inputSerial != "" {
-		deviceIdentifier = *inputSerial
-	} else if *inputProductID != 0 {
-		deviceIdentifier = strconv.Itoa(int(*inputProductID))
+	if len(specs) == 0 {
+		log.Fatal("No input device serial/product-id provided")
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	writer, err := output.NewWriter(deviceIdentifier)
-	if err != nil {
-		log.Fatalf("error creating writer: %v", err)
-	}
-
-	profilesPath := paths.ProfilesDir(deviceIdentifier)
-	if err := os.MkdirAll(profilesPath, 0755); err != nil {
-		log.Fatalf("error creating profile directory: %v", err)
+	var devices []*device
+	for _, spec := range specs {
+		dev, err := openDevice(ctx, spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		devices = append(devices, dev)
 	}
 
-	store := mapping.NewStore(profilesPath, productID)
-	if err := store.CreateIfNeeded(); err != nil {
-		log.Fatal(err)
-	}
-	store.LoadMetadata()
-	if err := store.ReloadAllProfiles(); err != nil {
-		log.Fatal("failed to load mapping:", err)
-	}
-	if err := store.ReloadActive(); err != nil {
-		log.Fatal("failed to load active:", err)
+	stores := make([]*mapping.Store, 0, len(devices))
+	webDevices := make([]web.Device, 0, len(devices))
+	for _, dev := range devices {
+		stores = append(stores, dev.store)
+		webDevices = append(webDevices, web.Device{
+			Identifier: dev.spec.identifier(),
+			Store:      dev.store,
+			Reader:     dev.reader,
+		})
 	}
 
-	go store.WatchProfiles(ctx)
-
-	metadata := store.Metadata.Load()
-	reader, err := input.NewReader(devicePath, metadata.InvertAxes)
+	switcher, err := mapping.NewProfileSwitcher(stores, 300*time.Millisecond)
 	if err != nil {
-		log.Fatalf("failed to start reader: %v", err)
-	}
-	if metadata.ExclusiveAccess {
-		reader.Grab()
-	} else {
-		reader.Ungrab()
-	}
-
-	if _, found := os.LookupEnv("WAYLAND_DISPLAY"); found {
-		log.Println("Active window watching disabled on wayland")
+		log.Printf("Active window watching disabled: %s", err)
 	} else {
 		log.Println("Watching active windows")
-		switcher, err := mapping.NewAutoProfileSwitcher(store, 300*time.Millisecond)
-		if err != nil {
-			log.Fatal(err)
-		}
 		go switcher.Start(ctx)
 	}
-	go web.RunServer(ctx, *port, store, reader, deviceIdentifier)
-	go internal.RunEventLoop(ctx, reader, store, writer)
+	go web.RunServer(ctx, *port, webDevices)
+	var loops sync.WaitGroup
+	for _, dev := range devices {
+		loops.Go(func() { internal.RunEventLoop(ctx, dev.reader, dev.store, dev.writer) })
+	}
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs,
@@ -146,16 +162,15 @@ func main() {
 		sig := <-sigs
 		log.Printf("[signal] caught %s, shutting down...", sig)
 		cancel()
+		// let the loops release their keys before the writers go away
+		loops.Wait()
 
 		mu.Lock()
 		defer mu.Unlock()
 
-		if reader != nil {
-			reader.Close()
-		}
-
-		if writer != nil {
-			writer.Close()
+		for _, dev := range devices {
+			dev.reader.Close()
+			dev.writer.Close()
 		}
 
 		os.Exit(0)
@@ -163,6 +178,7 @@ func main() {
 
 	log.Println("[daemon] started. press Ctrl+C to stop.")
 	<-ctx.Done()
+	loops.Wait()
 
 	if *memprofile != "" {
 		f, err := os.Create(*memprofile)
@@ -176,3 +192,69 @@ func main() {
 		}
 	}
 }
+
+// Waits for the device if asked to, then loads its profiles and opens its
+// input and output devices
+func openDevice(ctx context.Context, spec deviceSpec) (*device, error) {
+	identifier := spec.identifier()
+
+	log.Printf("Connecting to device %s", identifier)
+	var devicePath string
+	var productID uint16
+	var err error
+	var wroteMessage bool
+	for {
+		devicePath, productID, err = input.GetDevicePath(spec.serial, spec.productID)
+		if err != nil {
+			if !*wait {
+				return nil, fmt.Errorf("%s: %w", identifier, err)
+			}
+			if !wroteMessage {
+				log.Println("Retrying every 2s for device to be connected")
+				wroteMessage = true
+			}
+			time.Sleep(2 * time.Second)
+			continue
+		}
+		break
+	}
+	log.Printf("Connected to %s", identifier)
+
+	profilesPath := paths.ProfilesDir(identifier)
+	if err := os.MkdirAll(profilesPath, 0755); err != nil {
+		return nil, fmt.Errorf("error creating profile directory: %v", err)
+	}
+
+	store := mapping.NewStore(profilesPath, productID)
+	if err := store.CreateIfNeeded(); err != nil {
+		return nil, err
+	}
+	store.LoadMetadata()
+	if err := store.ReloadAllProfiles(); err != nil {
+		return nil, fmt.Errorf("failed to load mapping: %v", err)
+	}
+	if err := store.ReloadActive(); err != nil {
+		return nil, fmt.Errorf("failed to load active: %v", err)
+	}
+
+	go store.WatchProfiles(ctx)
+
+	metadata := store.Metadata.Load()
+	writer, err := output.NewWriter(identifier, metadata.VirtualGamepad)
+	if err != nil {
+		return nil, fmt.Errorf("error creating writer: %v", err)
+	}
+
+	reader, err := input.NewReader(devicePath, spec.serial, spec.productID, metadata.InvertAxes)
+	if err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("failed to start reader: %v", err)
+	}
+	if metadata.ExclusiveAccess {
+		reader.Grab()
+	} else {
+		reader.Ungrab()
+	}
+
+	return &device{spec: spec, store: store, reader: reader, writer: writer}, nil
+}
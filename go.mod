@@ -8,7 +8,6 @@ require (
 	github.com/bendahl/uinput v1.7.0
 	github.com/fsnotify/fsnotify v1.9.0
 	github.com/holoplot/go-evdev v0.0.0-20250804134636-ab1d56a1fe83
+	golang.org/x/sys v0.34.0
 	golang.org/x/text v0.29.0
 )
-
-require golang.org/x/sys v0.34.0 // indirect
@@ -1,11 +1,15 @@
 package input
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/caedis/noreza/internal/mapping"
 	"github.com/holoplot/go-evdev"
@@ -14,44 +18,91 @@ import (
 )
 
 type Reader struct {
-	dev *evdev.InputDevice
-	invertAxes  bool
+	// guards dev, which is swapped out when the device reconnects
+	mu         sync.Mutex
+	dev        *evdev.InputDevice
+	serial     string
+	productID  uint16
+	invertAxes bool
+	grabbed    bool
 }
 
-func NewReader(path string, invert_axes bool) (*Reader, error) {
+func NewReader(path, serial string, productID uint16, invert_axes bool) (*Reader, error) {
 	dev, err := evdev.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	return &Reader{
-		dev: dev,
+		dev:        dev,
+		serial:     serial,
+		productID:  productID,
 		invertAxes: invert_axes,
 	}, nil
 }
 
 func (r *Reader) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.dev.Close()
 }
 
+// Waits for the device to come back after a disconnect and reopens it,
+// keeping the previous grab state.
+func (r *Reader) Reconnect(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		path, _, err := GetDevicePath(r.serial, r.productID)
+		if err == nil {
+			dev, err := evdev.Open(path)
+			if err == nil {
+				r.mu.Lock()
+				r.dev.Close()
+				r.dev = dev
+				if r.grabbed {
+					r.dev.Grab()
+				}
+				r.mu.Unlock()
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sends events until the device goes away, then sends a single event that
+// isn't Ready.
 func (r *Reader) Stream(out chan<- mapping.JoystickEvent) {
+	r.mu.Lock()
+	dev := r.dev
+	r.mu.Unlock()
+
 	keyMap := make(map[evdev.EvCode]uint8)
-	keyEvents := r.dev.CapableEvents(evdev.EV_KEY)
+	keyEvents := dev.CapableEvents(evdev.EV_KEY)
 	for i, t := range keyEvents {
 		keyMap[t] = uint8(i)
 	}
 
 	invertScale := int16(1)
 	if r.invertAxes {
-        invertScale = int16(-1)
-    }
+		invertScale = int16(-1)
+	}
 
-	absInfos, err := r.dev.AbsInfos()
+	absInfos, err := dev.AbsInfos()
 	if err != nil {
-		log.Fatalln(err)
+		log.Println(err)
+		out <- mapping.JoystickEvent{}
+		return
 	}
 
 	for {
-		evt, err := r.dev.ReadOne()
+		evt, err := dev.ReadOne()
 		if err != nil {
 			out <- mapping.JoystickEvent{}
 			return
@@ -95,14 +146,37 @@ func (r *Reader) Stream(out chan<- mapping.JoystickEvent) {
 }
 
 func (r *Reader) Grab() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.grabbed = true
 	r.dev.Grab()
 }
 
 func (r *Reader) Ungrab() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.grabbed = false
 	r.dev.Ungrab()
 }
 
+func (r *Reader) Grabbed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.grabbed
+}
+
+func (r *Reader) Serial() string { return r.serial }
+
+// Path of the event device currently open
+func (r *Reader) Path() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dev.Path()
+}
+
 func (r *Reader) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if r.dev != nil {
 		id, err := r.dev.InputID()
 		if err != nil {
@@ -118,44 +192,102 @@ func (r *Reader) String() string {
 	return ""
 }
 
-func GetDevicePath(serial string, productID uint16) (string, uint16, error) {
+// Calls fn with each joystick under /dev/input/by-id until it returns false
+func eachJoystick(fn func(path string, dev *evdev.InputDevice) bool) error {
 	basePath := "/dev/input/by-id"
 
 	files, err := os.ReadDir(basePath)
-	if err != nil {
-		return "", 0, err
+	if os.IsNotExist(err) {
+		// only created once something is plugged in
+		return nil
+	} else if err != nil {
+		return err
 	}
 
+	// devices that can't be opened are skipped, and reported at the end so a
+	// missing permission doesn't look like nothing being plugged in
+	var openErrs []error
 	for _, file := range files {
-		if file.IsDir() {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), "event-joystick") {
 			continue
 		}
 
-		if strings.HasSuffix(file.Name(), "event-joystick") {
-			fullPath := filepath.Join(basePath, file.Name())
-			dev, err := evdev.Open(fullPath)
-			if err != nil {
-				continue
-			}
-			defer dev.Close()
-			inputID, err := dev.InputID()
-			if err != nil {
-				return "", 0, err
-			}
-			if serial != "" {
-				uid, _ := dev.UniqueID()
-				if uid == serial {
-					return fullPath, inputID.Product, nil
-				}
-			} else if productID != 0 {
-				if inputID.Product == productID {
-					return fullPath, inputID.Product, nil
-				}
+		fullPath := filepath.Join(basePath, file.Name())
+		dev, err := evdev.Open(fullPath)
+		if err != nil {
+			openErrs = append(openErrs, err)
+			continue
+		}
+		more := fn(fullPath, dev)
+		dev.Close()
+		if !more {
+			return nil
+		}
+	}
+	return errors.Join(openErrs...)
+}
+
+func GetDevicePath(serial string, productID uint16) (string, uint16, error) {
+	var path string
+	var found uint16
+	err := eachJoystick(func(fullPath string, dev *evdev.InputDevice) bool {
+		inputID, err := dev.InputID()
+		if err != nil {
+			return true
+		}
+		if serial != "" {
+			uid, _ := dev.UniqueID()
+			if uid != serial {
+				return true
 			}
+		} else if productID == 0 || inputID.Product != productID {
+			return true
 		}
+		path, found = fullPath, inputID.Product
+		return false
+	})
+	if path == "" {
+		if err != nil {
+			return "", 0, fmt.Errorf("device not found for serial or product-id: %w", err)
+		}
+		return "", 0, fmt.Errorf("device not found for serial or product-id")
 	}
+	return path, found, nil
+}
+
+const azeronVendorID = 0x16d0
+
+// An Azeron joystick found by ListDevices
+type FoundDevice struct {
+	Path      string `json:"path"`
+	ProductID uint16 `json:"product_id"`
+	Model     string `json:"model"`
+	Serial    string `json:"serial"`
+	// held by a running noreza with exclusive access. Only the daemon knows,
+	// so this is left to callers that can ask it.
+	Grabbed bool `json:"grabbed"`
+}
+
+// Lists every Azeron joystick plugged in, along with an error for any
+// joystick that couldn't be opened to check
+func ListDevices() ([]FoundDevice, error) {
+	devices := make([]FoundDevice, 0)
+	err := eachJoystick(func(path string, dev *evdev.InputDevice) bool {
+		inputID, err := dev.InputID()
+		if err != nil || inputID.Vendor != azeronVendorID {
+			return true
+		}
+
+		found := FoundDevice{Path: path, ProductID: inputID.Product}
+		if model, err := mapping.GetDeviceFromID(inputID.Product); err == nil {
+			found.Model = cases.Title(language.English).String(model)
+		}
+		found.Serial, _ = dev.UniqueID()
 
-	return "", 0, fmt.Errorf("device not found for serial or product-id")
+		devices = append(devices, found)
+		return true
+	})
+	return devices, err
 }
 
 func scaleAxisToInt16(value int32, min int32, max int32) int16 {
@@ -3,29 +3,102 @@ package internal
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/caedis/noreza/internal/input"
 	"github.com/caedis/noreza/internal/mapping"
 	"github.com/caedis/noreza/internal/output"
 )
 
+// how often to look for the device again after it's unplugged
+const reconnectInterval = 2 * time.Second
+
 func RunEventLoop(ctx context.Context, reader *input.Reader, store *mapping.Store, writer *output.Writer) {
 	events := make(chan mapping.JoystickEvent, 128)
 	go reader.Stream(events)
 
+	// fires when the store has timed work pending, e.g. tap-hold timeouts
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	// keeps the cursor moving while the stick is held in mouse mode, even
+	// when the device sends no new events. Only runs while it's held.
+	pointer := time.NewTicker(mapping.PointerInterval)
+	pointer.Stop()
+	defer pointer.Stop()
+	var pointing bool
+
+	// centres the virtual stick once a profile stops passing it through, and
+	// catches it up with the physical one when a profile starts to
+	var stickActive bool
+	syncStick := func() {
+		x, y, ok := store.GamepadStick()
+		if ok || stickActive {
+			writer.MoveStick(x, y)
+		}
+		stickActive = ok
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
+			// nothing is left pressed (or turbo firing) once the daemon stops
+			writer.Apply(nil, store.ReleaseAll())
 			return
 		case evt := <-events:
 			if !evt.Ready {
-				log.Fatal("read error")
-				return
+				log.Println("Device disconnected, waiting for it to return")
+				timer.Stop()
+				writer.Apply(nil, store.ReleaseAll())
+				writer.MoveStick(0, 0)
+				stickActive = false
+				pointer.Stop()
+				pointing = false
+				store.SetConnected(false)
+
+				if err := reader.Reconnect(ctx, reconnectInterval); err != nil {
+					return
+				}
+
+				log.Println("Device reconnected")
+				store.SetConnected(true)
+				go reader.Stream(events)
+				continue
 			}
 
 			store.BroadcastEvent(mapping.SSEEvent{Type: mapping.EventJoystick, Data: evt})
 			press, release := store.Resolve(evt)
 			writer.Apply(press, release)
+			if evt.Type == "axis" {
+				syncStick()
+			}
+		case <-store.Switched():
+			writer.Apply(nil, store.StopOtherTurbo())
+			// the stick may be held somewhere the new profile treats differently
+			syncStick()
+		case now := <-timer.C:
+			press, release := store.Tick(now)
+			writer.Apply(press, release)
+		case <-pointer.C:
+			if dx, dy := store.PointerMotion(); dx != 0 || dy != 0 {
+				writer.MoveMouse(dx, dy)
+			}
+			continue
+		}
+
+		if deadline, ok := store.NextDeadline(); ok {
+			timer.Reset(time.Until(deadline))
+		} else {
+			timer.Stop()
+		}
+
+		if active := store.PointerActive(); active != pointing {
+			if active {
+				pointer.Reset(mapping.PointerInterval)
+			} else {
+				pointer.Stop()
+			}
+			pointing = active
 		}
 	}
 }
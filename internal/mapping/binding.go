@@ -0,0 +1,195 @@
+package mapping
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+const DefaultHoldTimeout = 200 * time.Millisecond
+
+const (
+	DefaultTurboRate = 10
+	// shortest a turbo key is held down or let up for, faster than this and
+	// games start missing presses
+	minTurboInterval = 10 * time.Millisecond
+)
+
+// What a single input direction is bound to. Plain bindings are stored as a
+// bare list of keys so older profiles keep loading unchanged; anything with
+// extra behaviour is stored as an object.
+type Binding struct {
+	Keys []KeyMapping `json:"keys"`
+	// sent instead of Keys when the input is held past the hold timeout
+	Hold *HoldMapping `json:"hold,omitempty"`
+	// played back alongside Keys
+	Macro *Macro `json:"macro,omitempty"`
+	// presses and releases the keys over and over while the input is held
+	Turbo *TurboMapping `json:"turbo,omitempty"`
+	// keeps the keys down after the input is let go. Hold and turbo don't
+	// apply to latching bindings.
+	Latch LatchMode `json:"latch,omitempty"`
+}
+
+type LatchMode string
+
+const (
+	// keys are down for as long as the input is
+	LatchNone LatchMode = ""
+	// one press holds the keys down and the next lets them go
+	LatchToggle LatchMode = "toggle"
+	// keys stay down until the next other input is pressed and released,
+	// e.g. for sticky modifiers. Pressing it again first cancels it.
+	LatchOneShot LatchMode = "oneshot"
+)
+
+type HoldMapping struct {
+	Keys      []KeyMapping `json:"keys"`
+	TimeoutMs int          `json:"timeout_ms,omitempty"`
+	// resolve as a hold as soon as another input is pressed
+	Permissive bool `json:"permissive,omitempty"`
+}
+
+func (h *HoldMapping) Timeout() time.Duration {
+	if h.TimeoutMs <= 0 {
+		return DefaultHoldTimeout
+	}
+	return time.Duration(h.TimeoutMs) * time.Millisecond
+}
+
+type TurboMapping struct {
+	// presses per second, 0 for the default
+	Rate float64 `json:"rate,omitempty"`
+	// each press and release is randomly made up to this much shorter or
+	// longer, so the rhythm doesn't look automated
+	JitterMs int `json:"jitter_ms,omitempty"`
+}
+
+// How long until the keys next go down or up
+func (t *TurboMapping) interval() time.Duration {
+	rate := t.Rate
+	if rate <= 0 {
+		rate = DefaultTurboRate
+	}
+	half := time.Duration(float64(time.Second) / rate / 2)
+	if t.JitterMs > 0 {
+		jitter := time.Duration(t.JitterMs) * time.Millisecond
+		half += time.Duration(rand.Int64N(int64(2*jitter)+1)) - jitter
+	}
+	return max(half, minTurboInterval)
+}
+
+type MacroMode string
+
+const (
+	// plays through to the end once started
+	MacroOnce MacroMode = ""
+	// stops as soon as the input is released
+	MacroCancel MacroMode = "cancel"
+	// loops for as long as the input is held
+	MacroRepeat MacroMode = "repeat"
+)
+
+type MacroAction string
+
+const (
+	MacroTap  MacroAction = "tap"
+	MacroDown MacroAction = "down"
+	MacroUp   MacroAction = "up"
+	MacroWait MacroAction = "wait"
+)
+
+type MacroStep struct {
+	Action MacroAction `json:"action"`
+	Key    KeyMapping  `json:"key,omitzero"`
+	// how long a wait lasts, or how long a tap holds its key down
+	DelayMs int `json:"delay_ms,omitempty"`
+}
+
+// How long a tap step holds its key, TapDuration unless the step says
+func (s MacroStep) TapHold() time.Duration {
+	if s.DelayMs > 0 {
+		return time.Duration(s.DelayMs) * time.Millisecond
+	}
+	return TapDuration
+}
+
+type Macro struct {
+	Mode  MacroMode   `json:"mode,omitempty"`
+	Steps []MacroStep `json:"steps"`
+}
+
+// The keys to press for this binding, with the macro (if any) riding along
+// as a virtual key so its playback starts and stops with the input.
+func (b Binding) Output() []KeyMapping {
+	if b.Macro == nil || len(b.Macro.Steps) == 0 {
+		return b.Keys
+	}
+	return append(slices.Clone(b.Keys), KeyMapping{Mode: MacroPlayback, Macro: b.Macro})
+}
+
+// Avoids recursing into Binding's own (un)marshallers
+type bindingJSON Binding
+
+func (b Binding) MarshalJSON() ([]byte, error) {
+	if b.Hold == nil && b.Macro == nil && b.Turbo == nil && b.Latch == LatchNone {
+		keys := b.Keys
+		if keys == nil {
+			keys = []KeyMapping{}
+		}
+		return json.Marshal(keys)
+	}
+	return json.Marshal(bindingJSON(b))
+}
+
+func (b *Binding) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		*b = Binding{}
+		return json.Unmarshal(data, &b.Keys)
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+	// A lone key object is the V1 format
+	if _, ok := probe["code"]; ok {
+		var key KeyMapping
+		if err := json.Unmarshal(data, &key); err != nil {
+			return err
+		}
+		*b = Binding{Keys: []KeyMapping{key}}
+		return nil
+	}
+
+	var raw bindingJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*b = Binding(raw)
+	return nil
+}
+
+// Unbound entries are saved as code 0, so an empty slice isn't the only way
+// for an input to have nothing assigned.
+func (b Binding) IsBound() bool {
+	if b.Hold != nil && isBound(b.Hold.Keys) {
+		return true
+	}
+	if b.Macro != nil && len(b.Macro.Steps) > 0 {
+		return true
+	}
+	return isBound(b.Keys)
+}
+
+func isBound(keys []KeyMapping) bool {
+	for _, k := range keys {
+		if k.Code != 0 {
+			return true
+		}
+	}
+	return false
+}
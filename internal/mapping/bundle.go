@@ -0,0 +1,135 @@
+package mapping
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Current bundle format
+const BundleVersion = 1
+
+// One or more profiles packed into a single file for sharing
+type Bundle struct {
+	Version   int                 `json:"version"`
+	Model     string              `json:"model"`
+	ProductID uint16              `json:"product_id"`
+	Profiles  map[string]*Mapping `json:"profiles"`
+}
+
+// What to do with a bundled profile whose name is already taken
+type ImportConflict string
+
+const (
+	ImportRename    ImportConflict = "rename"
+	ImportOverwrite ImportConflict = "overwrite"
+	ImportSkip      ImportConflict = "skip"
+)
+
+// Where a bundled profile was imported to, To is empty if it was skipped
+type ImportedProfile struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Packs the named profiles, or every profile if none are named
+func (s *Store) ExportBundle(names ...string) (Bundle, error) {
+	model, err := GetDeviceFromID(s.ProductID)
+	if err != nil {
+		return Bundle{}, err
+	}
+
+	rawMappings := *s.RawMappings.Load()
+	if len(names) == 0 {
+		names = slices.Sorted(maps.Keys(rawMappings))
+	}
+
+	bundle := Bundle{
+		Version:   BundleVersion,
+		Model:     model,
+		ProductID: s.ProductID,
+		Profiles:  make(map[string]*Mapping),
+	}
+	for _, name := range names {
+		m, ok := rawMappings[name]
+		if !ok {
+			return Bundle{}, fmt.Errorf("profile %s not found", name)
+		}
+		bundle.Profiles[name] = m
+	}
+	return bundle, nil
+}
+
+// Saves the bundle's profiles, as long as it was made for the same model of
+// device. Every profile is checked before any is written, so a bad bundle
+// changes nothing. If a write still fails, the profiles saved before it are
+// returned with the error.
+func (s *Store) ImportBundle(bundle Bundle, conflict ImportConflict) ([]ImportedProfile, error) {
+	if bundle.Version < 1 || bundle.Version > BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+	model, err := GetDeviceFromID(s.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Model != model {
+		return nil, fmt.Errorf("bundle is for a %s but this device is a %s", bundle.Model, model)
+	}
+	if len(bundle.Profiles) == 0 {
+		return nil, errors.New("bundle has no profiles")
+	}
+
+	names := slices.Sorted(maps.Keys(bundle.Profiles))
+	for _, name := range names {
+		m := bundle.Profiles[name]
+		if m == nil || CleanProfileName(name) == "" {
+			return nil, fmt.Errorf("invalid profile %q in bundle", name)
+		}
+		for i, rule := range m.Rules() {
+			if err := rule.Validate(); err != nil {
+				return nil, fmt.Errorf("profile %s rule %d: %w", name, i+1, err)
+			}
+		}
+	}
+
+	// decide where everything goes first, so two bundled profiles can't land
+	// on the same name
+	plan := make([]ImportedProfile, 0, len(names))
+	taken := make(map[string]bool)
+	for _, name := range names {
+		target := CleanProfileName(name)
+		if s.ProfileExists(target) || taken[target] {
+			switch {
+			case conflict == ImportSkip:
+				plan = append(plan, ImportedProfile{From: name})
+				continue
+			case conflict == ImportOverwrite && !taken[target]:
+			default:
+				target = s.unusedProfileName(target, taken)
+			}
+		}
+		taken[target] = true
+		plan = append(plan, ImportedProfile{From: name, To: target})
+	}
+
+	var imported []ImportedProfile
+	for _, p := range plan {
+		if p.To != "" {
+			if err := s.WriteMapping(p.To, *bundle.Profiles[p.From]); err != nil {
+				return imported, fmt.Errorf("saving %s: %w", p.To, err)
+			}
+		}
+		imported = append(imported, p)
+	}
+	return imported, nil
+}
+
+func (s *Store) unusedProfileName(name string, taken map[string]bool) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d", name, i)
+		if !s.ProfileExists(candidate) && !taken[candidate] {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func newBundleStore(t *testing.T, existing ...string) *Store {
+	t.Helper()
+	s := NewStore(t.TempDir(), 4498)
+	raw := make(map[string]*Mapping)
+	flat := make(map[string]*FlatMapping)
+	for _, name := range existing {
+		raw[name] = &Mapping{}
+		flat[name] = CompileFlatMapping(Mapping{})
+	}
+	s.RawMappings.Store(&raw)
+	s.Mappings.Store(&flat)
+	return s
+}
+
+func TestImportBundleRejectsBeforeWriting(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles map[string]*Mapping
+	}{
+		{"invalid rule", map[string]*Mapping{
+			"a": {},
+			"b": {WindowRules: []WindowRule{{WindowProfileCfg: WindowProfileCfg{NamePattern: "("}}}},
+		}},
+		{"missing profile", map[string]*Mapping{"a": {}, "b": nil}},
+		{"unusable name", map[string]*Mapping{"a": {}, "/": {}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newBundleStore(t)
+			bundle := Bundle{Version: BundleVersion, Model: "classic", Profiles: tt.profiles}
+			imported, err := s.ImportBundle(bundle, ImportOverwrite)
+			if err == nil {
+				t.Fatal("bundle was accepted")
+			}
+			if len(imported) != 0 {
+				t.Errorf("reported imports %v for a rejected bundle", imported)
+			}
+			files, _ := os.ReadDir(s.ProfilePath)
+			if len(files) != 0 {
+				t.Errorf("profiles written for a rejected bundle: %v", files)
+			}
+		})
+	}
+}
+
+func TestImportBundleRenames(t *testing.T) {
+	s := newBundleStore(t, "a")
+	bundle := Bundle{Version: BundleVersion, Model: "classic", Profiles: map[string]*Mapping{"a": {}, "b": {}}}
+	imported, err := s.ImportBundle(bundle, ImportRename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ImportedProfile{{From: "a", To: "a-2"}, {From: "b", To: "b"}}
+	if !slices.Equal(imported, want) {
+		t.Errorf("imported %v, want %v", imported, want)
+	}
+	for _, p := range want {
+		if _, err := os.Stat(filepath.Join(s.ProfilePath, p.To+".json")); err != nil {
+			t.Errorf("%s not written: %s", p.To, err)
+		}
+	}
+}
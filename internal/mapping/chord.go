@@ -0,0 +1,116 @@
+package mapping
+
+import (
+	"slices"
+	"time"
+)
+
+const DefaultChordWindow = 50 * time.Millisecond
+
+// Presses of chord buttons are held back until they either complete a chord,
+// can no longer be part of one, or the chord window runs out.
+func (m *FlatMapping) pressChordButton(s *Store, index uint8, now time.Time, out *resolved) {
+	buffer := append(slices.Clone(s.chordBuffer), index)
+	if !m.ChordButtons[index] || !m.couldChord(buffer) {
+		// what's waiting can't become a chord with this button
+		m.flushChord(s, now, out)
+		if !m.ChordButtons[index] {
+			m.pressButton(s, index, now, out)
+			return
+		}
+		buffer = []uint8{index}
+	}
+
+	if len(s.chordBuffer) == 0 {
+		s.chordDeadline = now.Add(m.ChordWindow)
+	}
+	s.chordBuffer = buffer
+	if chord, ok := m.matchChord(buffer); ok && !m.chordCouldGrow(buffer) {
+		m.fireChord(s, chord, now, out)
+	}
+}
+
+func (m *FlatMapping) releaseChordButton(s *Store, index uint8, now time.Time, out *resolved) {
+	if slices.Contains(s.chordBuffer, index) {
+		// let go before anything was decided, so decide now. Whatever it
+		// presses is only just being sent, so it's released a tap later.
+		m.resolveChord(s, now, out)
+		if input, ok := s.chordMembers[index]; ok {
+			delete(s.chordMembers, index)
+			s.releaseInputLater(input, now, out)
+		} else if !s.releaseLayer(index) {
+			s.releaseInputLater(key(index, "button"), now, out)
+		}
+		return
+	}
+
+	// each of a chord's buttons releases the chord when let go. It's only
+	// held until the first one, after that releasing is a no-op, and none of
+	// them release their own bindings since those were never pressed.
+	if input, ok := s.chordMembers[index]; ok {
+		delete(s.chordMembers, index)
+		s.releaseInput(input, now, out)
+		return
+	}
+	m.releaseButton(s, index, now, out)
+}
+
+// Fires the chord matching the waiting buttons, if any, and otherwise presses
+// them one by one
+func (m *FlatMapping) resolveChord(s *Store, now time.Time, out *resolved) {
+	if chord, ok := m.matchChord(s.chordBuffer); ok {
+		m.fireChord(s, chord, now, out)
+		return
+	}
+	m.flushChord(s, now, out)
+}
+
+func (m *FlatMapping) flushChord(s *Store, now time.Time, out *resolved) {
+	buffer := s.chordBuffer
+	s.chordBuffer = nil
+	for _, index := range buffer {
+		m.pressButton(s, index, now, out)
+	}
+}
+
+func (m *FlatMapping) fireChord(s *Store, chord FlatChord, now time.Time, out *resolved) {
+	input := key(chord.Index, "chord")
+	for _, index := range s.chordBuffer {
+		s.chordMembers[index] = input
+	}
+	s.chordBuffer = nil
+	s.pressInput(m, input, control("chord", chord.Index, ""), chord.Binding, now, out)
+}
+
+func (m *FlatMapping) matchChord(buttons []uint8) (FlatChord, bool) {
+	sorted := slices.Sorted(slices.Values(buttons))
+	for _, chord := range m.Chords {
+		if slices.Equal(chord.Buttons, sorted) {
+			return chord, true
+		}
+	}
+	return FlatChord{}, false
+}
+
+// Whether the buttons are all part of some chord
+func (m *FlatMapping) couldChord(buttons []uint8) bool {
+	return slices.ContainsFunc(m.Chords, func(chord FlatChord) bool {
+		return containsAll(chord.Buttons, buttons)
+	})
+}
+
+// Whether some longer chord starts with the buttons
+func (m *FlatMapping) chordCouldGrow(buttons []uint8) bool {
+	return slices.ContainsFunc(m.Chords, func(chord FlatChord) bool {
+		return len(chord.Buttons) > len(buttons) && containsAll(chord.Buttons, buttons)
+	})
+}
+
+func containsAll(set, values []uint8) bool {
+	for _, v := range values {
+		if !slices.Contains(set, v) {
+			return false
+		}
+	}
+	return true
+}
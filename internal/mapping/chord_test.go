@@ -0,0 +1,92 @@
+package mapping
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var (
+	chordKeyA   = KeyMapping{Code: 30, Mode: Keyboard}
+	chordKeyB   = KeyMapping{Code: 48, Mode: Keyboard}
+	chordKeyF12 = KeyMapping{Code: 88, Mode: Keyboard}
+	chordKeyUp  = KeyMapping{Code: 103, Mode: Keyboard}
+)
+
+// Buttons 0 and 1 press A and B, together F12, and the hat's up presses Up
+func newChordStore(t *testing.T) *Store {
+	t.Helper()
+	s := newTestStore(t, map[string]Mapping{"chords": {
+		BindingSet: BindingSet{
+			Buttons: map[uint8]Binding{
+				0: {Keys: []KeyMapping{chordKeyA}},
+				1: {Keys: []KeyMapping{chordKeyB}},
+			},
+			Hats: map[uint8]HatMapping{0: {Up: Binding{Keys: []KeyMapping{chordKeyUp}}}},
+		},
+		Chords: []Chord{{Buttons: []uint8{1, 0}, Binding: Binding{Keys: []KeyMapping{chordKeyF12}}}},
+	}})
+	s.setActive("chords")
+	return s
+}
+
+func resolve(s *Store, evt JoystickEvent, now time.Time) ([]KeyMapping, []KeyMapping) {
+	return s.ActiveMapping.Load().Resolve(s, evt, now)
+}
+
+func TestChordReplacesButtons(t *testing.T) {
+	s := newChordStore(t)
+	w := newFakeWriter()
+	now := time.Now()
+
+	w.Apply(resolve(s, JoystickEvent{Type: "button", Index: 0, Value: 1}, now))
+	w.Apply(resolve(s, JoystickEvent{Type: "button", Index: 1, Value: 1}, now.Add(10*time.Millisecond)))
+	if !w.held[chordKeyF12] {
+		t.Fatalf("chord not pressed, held %v", w.held)
+	}
+
+	w.Apply(resolve(s, JoystickEvent{Type: "button", Index: 0, Value: 0}, now.Add(time.Second)))
+	if w.held[chordKeyF12] {
+		t.Errorf("chord still held after one of its buttons was released")
+	}
+	w.Apply(resolve(s, JoystickEvent{Type: "button", Index: 1, Value: 0}, now.Add(2*time.Second)))
+	w.Apply(s.Tick(now.Add(3 * time.Second)))
+
+	if w.pressed[chordKeyA] || w.pressed[chordKeyB] {
+		t.Errorf("the chord's buttons pressed their own bindings: %v", w.pressed)
+	}
+	if len(w.held) != 0 {
+		t.Errorf("keys left held: %v", w.held)
+	}
+}
+
+func TestChordWindowExpires(t *testing.T) {
+	s := newChordStore(t)
+	w := newFakeWriter()
+	now := time.Now()
+
+	w.Apply(resolve(s, JoystickEvent{Type: "button", Index: 0, Value: 1}, now))
+	if len(w.held) != 0 {
+		t.Fatalf("pressed before the chord window ran out: %v", w.held)
+	}
+	w.Apply(s.Tick(now.Add(DefaultChordWindow)))
+	if !w.held[chordKeyA] {
+		t.Fatalf("button's own binding not pressed once the window ran out, held %v", w.held)
+	}
+
+	w.Apply(resolve(s, JoystickEvent{Type: "button", Index: 0, Value: 0}, now.Add(time.Second)))
+	if len(w.held) != 0 {
+		t.Errorf("keys left held: %v", w.held)
+	}
+}
+
+func TestChordFlushedByOtherInput(t *testing.T) {
+	s := newChordStore(t)
+	now := time.Now()
+
+	resolve(s, JoystickEvent{Type: "button", Index: 0, Value: 1}, now)
+	pressed, _ := resolve(s, JoystickEvent{Type: "hat", Index: 0, Value: 1}, now.Add(10*time.Millisecond))
+	if want := []KeyMapping{chordKeyA, chordKeyUp}; !slices.Equal(pressed, want) {
+		t.Errorf("pressed %v, want the waiting button first: %v", pressed, want)
+	}
+}
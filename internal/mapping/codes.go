@@ -1,8 +1,17 @@
 package mapping
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+)
 
-// Maps KeyboardEvent.code strings to Linux input-event codes
+//go:generate go run gen_keycodes.go
+
+// Maps KeyboardEvent.code strings to Linux input-event codes, as defined in
+// the kernel's include/uapi/linux/input-event-codes.h. Keys the browser has
+// no code for use the kernel's KEY_* name in the same style. The browser
+// names are kept by hand, while kernelKeyCodes is generated from the header.
+// Both stop at MaxKeyCode.
 var KeyToCode = map[string]int{
 	// Letters
 	"KeyA": 30, "KeyB": 48, "KeyC": 46, "KeyD": 32, "KeyE": 18, "KeyF": 33,
@@ -18,12 +27,16 @@ var KeyToCode = map[string]int{
 	// Function keys
 	"F1": 59, "F2": 60, "F3": 61, "F4": 62, "F5": 63,
 	"F6": 64, "F7": 65, "F8": 66, "F9": 67, "F10": 68,
-	"F11": 87, "F12": 88,
+	"F11": 87, "F12": 88, "F13": 183, "F14": 184, "F15": 185,
+	"F16": 186, "F17": 187, "F18": 188, "F19": 189, "F20": 190,
+	"F21": 191, "F22": 192, "F23": 193, "F24": 194,
 
 	// Modifiers & controls
 	"Escape": 1, "Tab": 15, "CapsLock": 58, "ShiftLeft": 42, "ShiftRight": 54,
 	"ControlLeft": 29, "ControlRight": 97, "AltLeft": 56, "AltRight": 100,
+	"MetaLeft": 125, "MetaRight": 126, "ContextMenu": 127, "Menu": 139,
 	"Space": 57, "Enter": 28, "Backspace": 14,
+	"PrintScreen": 99, "ScrollLock": 70, "Pause": 119,
 
 	// Arrows
 	"ArrowUp": 103, "ArrowDown": 108, "ArrowLeft": 105, "ArrowRight": 106,
@@ -35,18 +48,151 @@ var KeyToCode = map[string]int{
 	// Symbols
 	"Minus": 12, "Equal": 13, "BracketLeft": 26, "BracketRight": 27,
 	"Semicolon": 39, "Quote": 40, "Backquote": 41, "Backslash": 43,
-	"Comma": 51, "Period": 52, "Slash": 53,
+	"Comma": 51, "Period": 52, "Slash": 53, "IntlBackslash": 86,
 
 	// Numpad
 	"NumLock": 69, "NumpadDivide": 98, "NumpadMultiply": 55, "NumpadSubtract": 74,
 	"NumpadAdd": 78, "NumpadEnter": 96, "Numpad1": 79, "Numpad2": 80,
 	"Numpad3": 81, "Numpad4": 75, "Numpad5": 76, "Numpad6": 77,
 	"Numpad7": 71, "Numpad8": 72, "Numpad9": 73, "Numpad0": 82,
-	"NumpadDecimal": 83,
+	"NumpadDecimal": 83, "NumpadEqual": 117, "NumpadComma": 121,
+	"NumpadChangeSign": 118, "NumpadParenLeft": 179, "NumpadParenRight": 180,
+	"NumpadJpComma": 95,
+
+	// International
+	"IntlRo": 89, "IntlYen": 124, "KanaMode": 93, "Convert": 92,
+	"NonConvert": 94, "Lang1": 122, "Lang2": 123, "Lang3": 90,
+	"Lang4": 91, "Lang5": 85,
+
+	// Media
+	"AudioVolumeMute": 113, "AudioVolumeDown": 114, "AudioVolumeUp": 115,
+	"MediaPlayPause": 164, "MediaStop": 166, "MediaTrackNext": 163,
+	"MediaTrackPrevious": 165, "MediaPlay": 207, "MediaPause": 201,
+	"MediaRecord": 167, "MediaRewind": 168, "MediaFastForward": 208,
+	"MediaSelect": 226, "Eject": 161, "MicMute": 248, "BassBoost": 209,
+	"Sound": 213, "PlayCD": 200, "CloseCD": 160, "EjectCloseCD": 162,
+
+	// Browser & launchers
+	"BrowserBack": 158, "BrowserForward": 159, "BrowserRefresh": 173,
+	"BrowserStop": 128, "BrowserSearch": 217, "BrowserFavorites": 156,
+	"BrowserHome": 172, "LaunchMail": 155, "LaunchApp1": 144, "LaunchApp2": 140,
+	"MailSend": 231, "MailReply": 232, "MailForward": 233,
+	"Computer": 157, "WWW": 150, "Email": 215, "Chat": 216, "Phone": 169,
+	"Camera": 212, "Finance": 219, "Sport": 220, "Shop": 221, "Connect": 218,
+	"Documents": 235, "AllApplications": 204, "Prog1": 148, "Prog2": 149,
+	"Prog3": 202, "Prog4": 203, "MSDOS": 151, "HP": 211, "Question": 214,
+
+	// Editing
+	"Undo": 131, "Redo": 182, "Again": 129, "Copy": 133, "Cut": 137,
+	"Paste": 135, "Find": 136, "Open": 134, "Props": 130, "Select": 132,
+	"Help": 138, "New": 181, "Close": 206, "Save": 234, "Print": 210,
+	"Cancel": 223, "Exit": 174, "Move": 175, "Edit": 176, "Setup": 141,
+	"Config": 171, "SendFile": 145, "DeleteFile": 146, "Xfer": 147,
+	"AltErase": 222, "Linefeed": 101, "Macro": 112, "ISO": 170,
+	"ScrollUp": 177, "ScrollDown": 178,
+
+	// System
+	"Power": 116, "Sleep": 142, "WakeUp": 143, "Suspend": 205,
+	"ScreenLock": 152, "RotateDisplay": 153, "CycleWindows": 154, "Scale": 120,
+	"BrightnessDown": 224, "BrightnessUp": 225, "BrightnessCycle": 243,
+	"BrightnessAuto": 244, "DisplayOff": 245, "SwitchVideoMode": 227,
+	"VideoNext": 241, "VideoPrev": 242, "KbdIllumToggle": 228,
+	"KbdIllumDown": 229, "KbdIllumUp": 230, "Battery": 236, "Bluetooth": 237,
+	"WLAN": 238, "UWB": 239, "WWAN": 246, "RFKill": 247,
+}
+
+// Highest keycode the virtual keyboard registers (KEY_MICMUTE). It's
+// bendahl/uinput's limit, keys past it can't be sent even though the kernel
+// defines them.
+const MaxKeyCode = 248
+
+// Prefix for keys bound by raw keycode, e.g. "Code240"
+const rawKeyPrefix = "Code"
+
+// Looks up a key by name, or by its kernel KEY_* name, falling back to a
+// raw keycode for keys that aren't in either table.
+func ParseKey(name string) (int, bool) {
+	if code, ok := KeyToCode[name]; ok {
+		return code, true
+	}
+	if code, ok := kernelKeyCodes[name]; ok {
+		return code, true
+	}
+	// only "Code" and digits, so a bare or signed number isn't taken as a
+	// keycode by mistake
+	rest, ok := strings.CutPrefix(name, rawKeyPrefix)
+	if !ok || rest == "" || strings.Trim(rest, "0123456789") != "" {
+		return 0, false
+	}
+	code, err := strconv.Atoi(rest)
+	if err != nil || code <= 0 || code > MaxKeyCode {
+		return 0, false
+	}
+	return code, true
+}
+
+// Name for a keycode, as accepted by ParseKey
+func KeyName(code int) string {
+	if name, ok := CodeToKey[code]; ok {
+		return name
+	}
+	return rawKeyPrefix + strconv.Itoa(code)
+}
+
+// Looks up a keyboard key, mouse button or gamepad button by name
+func ParseOutputKey(name string) (KeyMapping, bool) {
+	if code, ok := ParseKey(name); ok {
+		return KeyMapping{Code: code, Mode: Keyboard}, true
+	}
+	if code, ok := MouseToCode[name]; ok {
+		return KeyMapping{Code: code, Mode: Mouse}, true
+	}
+	if code, ok := GamepadToCode[name]; ok {
+		return KeyMapping{Code: code, Mode: Gamepad}, true
+	}
+	return KeyMapping{}, false
+}
+
+// Labels that read better than what the name cleanup below produces
+var keyLabels = map[string]string{
+	"PrintScreen":        "PrtSc",
+	"ScrollLock":         "ScrLk",
+	"IntlBackslash":      "Intl\\",
+	"AudioVolumeMute":    "Mute",
+	"AudioVolumeDown":    "Vol-",
+	"AudioVolumeUp":      "Vol+",
+	"MediaPlayPause":     "Play/Pause",
+	"MediaTrackNext":     "Next Track",
+	"MediaTrackPrevious": "Prev Track",
+	"LaunchApp1":         "MyComputer",
+	"LaunchApp2":         "Calculator",
+	"LaunchMail":         "Mail",
 }
 
+// Wheel outputs reuse the kernel's REL_WHEEL and REL_HWHEEL codes, with the
+// sign giving the scroll direction.
+const (
+	WheelUp    = 0x08
+	WheelDown  = -0x08
+	WheelRight = 0x06
+	WheelLeft  = -0x06
+)
+
 var MouseToCode = map[string]int{
 	"LClick": 0x110, "RClick": 0x111, "MClick": 0x112,
+	"Back": 0x113, "Forward": 0x114,
+	"WheelUp": WheelUp, "WheelDown": WheelDown,
+	"WheelLeft": WheelLeft, "WheelRight": WheelRight,
+}
+
+// Virtual gamepad buttons, named by position rather than by label since
+// controllers disagree on which face button is which.
+var GamepadToCode = map[string]int{
+	"PadSouth": 0x130, "PadEast": 0x131, "PadNorth": 0x133, "PadWest": 0x134,
+	"PadLB": 0x136, "PadRB": 0x137, "PadLT": 0x138, "PadRT": 0x139,
+	"PadSelect": 0x13a, "PadStart": 0x13b, "PadHome": 0x13c,
+	"PadL3": 0x13d, "PadR3": 0x13e,
+	"PadUp": 0x220, "PadDown": 0x221, "PadLeft": 0x222, "PadRight": 0x223,
 }
 
 // Reverse map: Linux input-event code -> KeyboardEvent.code
@@ -61,12 +207,19 @@ var CodeToKey = func() map[int]string {
 var CodeToKeyFriendly = func() map[int]string {
 	m := make(map[int]string, len(KeyToCode))
 	for k, v := range KeyToCode {
+		if label, ok := keyLabels[k]; ok {
+			m[v] = label
+			continue
+		}
 		key := k
 		key = strings.ReplaceAll(key, "Key", "")
 		key = strings.ReplaceAll(key, "Digit", "")
 		key = strings.ReplaceAll(key, "Numpad", "NP_")
 		key = strings.ReplaceAll(key, "Arrow", "")
 		key = strings.ReplaceAll(key, "Control", "Ctrl")
+		key = strings.ReplaceAll(key, "Meta", "Super")
+		key = strings.ReplaceAll(key, "Browser", "")
+		key = strings.ReplaceAll(key, "Media", "")
 		m[v] = key
 	}
 	return m
@@ -79,3 +232,11 @@ var CodeToMouse = func() map[int]string {
 	}
 	return m
 }()
+
+var CodeToGamepad = func() map[int]string {
+	m := make(map[int]string, len(GamepadToCode))
+	for k, v := range GamepadToCode {
+		m[v] = k
+	}
+	return m
+}()
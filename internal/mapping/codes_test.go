@@ -0,0 +1,51 @@
+package mapping
+
+import "testing"
+
+// The browser names are kept by hand, so check they all land on keys the
+// kernel defines
+func TestKeyToCodeMatchesKernel(t *testing.T) {
+	kernel := make(map[int]bool)
+	for _, code := range kernelKeyCodes {
+		kernel[code] = true
+	}
+	for name, code := range KeyToCode {
+		if !kernel[code] {
+			t.Errorf("%s: %d isn't a kernel keycode up to %d", name, code, MaxKeyCode)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		ok   bool
+	}{
+		{"KeyE", 18, true},
+		{"F24", 194, true},
+		{"KEY_MICMUTE", 248, true},
+		{"KEY_HANGUEL", 122, true},
+		{"Code240", 240, true},
+		{"Code248", 248, true},
+		// past what the virtual keyboard can send
+		{"Code249", 0, false},
+		{"KEY_MAX", 0, false},
+		{"Code0", 0, false},
+		{"NotAKey", 0, false},
+		// numbers need the Code prefix, and no sign
+		{"5", 0, false},
+		{"+5", 0, false},
+		{"-5", 0, false},
+		{"Code+5", 0, false},
+		{"Code-5", 0, false},
+		{"Code", 0, false},
+		{"Code 5", 0, false},
+	}
+	for _, tt := range tests {
+		code, ok := ParseKey(tt.name)
+		if code != tt.code || ok != tt.ok {
+			t.Errorf("ParseKey(%q) = %d, %t, want %d, %t", tt.name, code, ok, tt.code, tt.ok)
+		}
+	}
+}
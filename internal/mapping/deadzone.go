@@ -0,0 +1,125 @@
+package mapping
+
+import "math"
+
+// Outside a radial deadzone the stick is split into eight equal sectors. A
+// direction only lets go once the stick is a few degrees into the next
+// sector, so diagonals don't flicker.
+var (
+	sectorEnter = math.Sin(22.5 * math.Pi / 180)
+	sectorExit  = math.Sin(17.5 * math.Pi / 180)
+)
+
+// Inner and outer deadzone of an axis, with defaults filled in
+func (m *FlatMapping) axisDeadzone(index uint8) (float64, float64) {
+	cfg := m.AxisDeadzones[index]
+	inner, outer := float64(m.AxisDeadzone), float64(math.MaxInt16)
+	if cfg.Inner > 0 {
+		inner = float64(cfg.Inner)
+	}
+	if cfg.Outer > 0 {
+		outer = float64(cfg.Outer)
+	}
+	return inner, outer
+}
+
+func (m *FlatMapping) radialDeadzone() bool {
+	switch m.DeadzoneShape {
+	case DeadzoneRadial:
+		return true
+	case DeadzoneAxial:
+		return false
+	}
+	return m.JoystickMode == JoystickGamepad
+}
+
+// Distance from the centre to the edge of an ellipse with radii rx and ry,
+// along the unit vector (dx, dy). Per axis deadzones make radial ones
+// elliptical.
+func ellipseRadius(rx, ry, dx, dy float64) float64 {
+	if rx <= 0 || ry <= 0 {
+		return 0
+	}
+	return 1 / math.Hypot(dx/rx, dy/ry)
+}
+
+// Which way an axis is pushed in keys mode, given which way it was last
+func (m *FlatMapping) axisDirection(index uint8, value int16, prev int8) int8 {
+	inner, _ := m.axisDeadzone(index)
+	exit := max(inner-float64(m.DeadzoneHysteresis), 0)
+
+	v := float64(value)
+	switch {
+	case v >= inner || (prev > 0 && v > exit):
+		return 1
+	case v <= -inner || (prev < 0 && v < -exit):
+		return -1
+	}
+	return 0
+}
+
+// Which way both stick axes are pushed in keys mode with a radial deadzone
+func (m *FlatMapping) stickDirections(x, y int16, prevX, prevY int8) (int8, int8) {
+	fx, fy := float64(x), float64(y)
+	magnitude := math.Hypot(fx, fy)
+	if magnitude == 0 {
+		return 0, 0
+	}
+	dx, dy := fx/magnitude, fy/magnitude
+
+	innerX, _ := m.axisDeadzone(StickXAxis)
+	innerY, _ := m.axisDeadzone(StickYAxis)
+	inner := ellipseRadius(innerX, innerY, dx, dy)
+	if prevX != 0 || prevY != 0 {
+		if magnitude <= max(inner-float64(m.DeadzoneHysteresis), 0) {
+			return 0, 0
+		}
+	} else if magnitude < inner {
+		return 0, 0
+	}
+
+	return sectorDirection(dx, prevX), sectorDirection(dy, prevY)
+}
+
+func sectorDirection(d float64, prev int8) int8 {
+	switch {
+	case d >= sectorEnter || (prev > 0 && d >= sectorExit):
+		return 1
+	case d <= -sectorEnter || (prev < 0 && d <= -sectorExit):
+		return -1
+	}
+	return 0
+}
+
+// Rescales the stick to -1..1 between its inner and outer deadzones, applying
+// the response curve
+func (m *FlatMapping) scaleStick(x, y int16, curve float64) (float64, float64) {
+	innerX, outerX := m.axisDeadzone(StickXAxis)
+	innerY, outerY := m.axisDeadzone(StickYAxis)
+	if !m.radialDeadzone() {
+		return scaleAxis(x, innerX, outerX, curve), scaleAxis(y, innerY, outerY, curve)
+	}
+
+	fx, fy := float64(x), float64(y)
+	magnitude := math.Hypot(fx, fy)
+	if magnitude == 0 {
+		return 0, 0
+	}
+	dx, dy := fx/magnitude, fy/magnitude
+
+	inner := ellipseRadius(innerX, innerY, dx, dy)
+	outer := ellipseRadius(outerX, outerY, dx, dy)
+	if magnitude <= inner || outer <= inner {
+		return 0, 0
+	}
+	scaled := math.Pow(min((magnitude-inner)/(outer-inner), 1), curve)
+	return scaled * dx, scaled * dy
+}
+
+func scaleAxis(value int16, inner, outer, curve float64) float64 {
+	v := float64(value)
+	if math.Abs(v) <= inner || outer <= inner {
+		return 0
+	}
+	return math.Copysign(math.Pow(min((math.Abs(v)-inner)/(outer-inner), 1), curve), v)
+}
@@ -2,39 +2,68 @@ package mapping
 
 import (
 	"fmt"
+	"math"
+	"slices"
+	"time"
 )
 
-type FlatMapping struct {
-	ButtonMap    map[uint8][]KeyMapping
-	AxisPos      map[uint8][]KeyMapping
-	AxisNeg      map[uint8][]KeyMapping
-	AxisDeadzone int16
-	HatDir       map[string][]KeyMapping
+type FlatBindings struct {
+	ButtonMap map[uint8]Binding
+	AxisPos   map[uint8]Binding
+	AxisNeg   map[uint8]Binding
+	HatDir    map[string]Binding
+	SectorMap map[uint8]Binding
+	// outer ring of sectors mode
+	SectorRing Binding
 }
 
-func (m *FlatMapping) Resolve(s *Store, evt JoystickEvent) ([]KeyMapping, []KeyMapping) {
-	var pressed, released []KeyMapping
+type FlatLayer struct {
+	FlatBindings
+	Name   string
+	Toggle bool
+}
 
-	markPressed := func(keys []KeyMapping) {
-		for _, key := range keys {
-			pressed = append(pressed, key)
-		}
-	}
+type FlatChord struct {
+	// sorted, for comparing against what's pressed
+	Buttons []uint8
+	Binding Binding
+	// position in the profile's chords
+	Index uint8
+}
 
-	markReleased := func(keys []KeyMapping) {
-		for _, key := range keys {
-			released = append(released, key)
-		}
-	}
+type FlatMapping struct {
+	FlatBindings
+	AxisDeadzone       int16
+	AxisDeadzones      map[uint8]AxisDeadzoneCfg
+	DeadzoneShape      DeadzoneShape
+	DeadzoneHysteresis int16
+	JoystickMode       JoystickMode
+	SectorCount        int
+	RingThreshold      int16
+	Mouse              MouseCfg
+	GamepadCurve       float64
+	WheelRepeat        time.Duration
+	// layers keyed by name, and by the button that activates them
+	Layers       map[string]*FlatLayer
+	LayerButtons map[uint8]*FlatLayer
+	Chords       []FlatChord
+	// buttons that are part of any chord, whose presses wait to see if the
+	// rest of the chord follows
+	ChordButtons map[uint8]bool
+	ChordWindow  time.Duration
+}
+
+func (m *FlatMapping) Resolve(s *Store, evt JoystickEvent, now time.Time) ([]KeyMapping, []KeyMapping) {
+	var out resolved
 
 	switch evt.Type {
 	case "button":
-		if keys, ok := m.ButtonMap[evt.Index]; ok {
-			if evt.Value > 0 {
-				markPressed(keys)
-			} else {
-				markReleased(keys)
-			}
+		if evt.Value == 0 {
+			m.releaseChordButton(s, evt.Index, now, &out)
+		} else if m.ChordButtons[evt.Index] || len(s.chordBuffer) > 0 {
+			m.pressChordButton(s, evt.Index, now, &out)
+		} else {
+			m.pressButton(s, evt.Index, now, &out)
 		}
 
 	case "hat":
@@ -44,96 +73,256 @@ func (m *FlatMapping) Resolve(s *Store, evt JoystickEvent) ([]KeyMapping, []KeyM
 		currKey := key(evt.Index, dirVal(curr))
 
 		if prevKey != currKey {
-			if hatKeys, ok := m.HatDir[prevKey]; ok {
-				markReleased(hatKeys)
-			}
-			if hatKeys, ok := m.HatDir[currKey]; ok {
-				markPressed(hatKeys)
+			input := key(evt.Index, "hat")
+			s.releaseInput(input, now, &out)
+			if dirVal(curr) != "" {
+				binding := m.lookup(s, func(b *FlatBindings) Binding { return b.HatDir[currKey] })
+				s.pressInput(m, input, control("hat", evt.Index, dirVal(curr)), binding, now, &out)
 			}
 		}
 
 		s.lastHat[evt.Index] = curr
 
 	case "axis":
-		if keys, ok := m.ResolveAxisKey(evt.Index, evt.Value); ok {
-			prev := s.lastAxis[evt.Index]
-			var dir int8
-			if evt.Value <= -m.AxisDeadzone {
-				dir = -1
-			} else if evt.Value >= m.AxisDeadzone {
-				dir = +1
+		s.axisValue[evt.Index] = evt.Value
+		if m.JoystickMode != JoystickSectors && isStickAxis(evt.Index) {
+			// a profile in sectors mode may have left a sector held
+			m.setSector(s, -1, false, now, &out)
+		}
+		if m.JoystickMode != JoystickKeys && isStickAxis(evt.Index) {
+			// a profile in keys mode may have left a direction held
+			s.releaseInput(key(evt.Index, "axis"), now, &out)
+			s.lastAxis[evt.Index] = 0
+			if m.JoystickMode == JoystickSectors {
+				sector, ring := m.stickSector(s.axisValue[StickXAxis], s.axisValue[StickYAxis], s.lastSector, s.ringHeld)
+				m.setSector(s, sector, ring, now, &out)
 			}
+			break
+		}
 
-			if prev != dir {
-				if dir != 0 {
-					markPressed(keys)
-				} else {
-					markReleased(keys)
-				}
+		if isStickAxis(evt.Index) && m.radialDeadzone() {
+			// the deadzone depends on both axes, so either can change both
+			dirX, dirY := m.stickDirections(s.axisValue[StickXAxis], s.axisValue[StickYAxis],
+				s.lastAxis[StickXAxis], s.lastAxis[StickYAxis])
+			m.setAxisDirection(s, StickXAxis, dirX, now, &out)
+			m.setAxisDirection(s, StickYAxis, dirY, now, &out)
+			break
+		}
+		m.setAxisDirection(s, evt.Index, m.axisDirection(evt.Index, evt.Value, s.lastAxis[evt.Index]), now, &out)
+	}
+
+	return out.pressed, out.released
+}
+
+func (m *FlatMapping) pressButton(s *Store, index uint8, now time.Time, out *resolved) {
+	if layer, ok := m.LayerButtons[index]; ok {
+		s.pressLayer(index, layer)
+		return
+	}
+	binding := m.lookup(s, func(b *FlatBindings) Binding { return b.ButtonMap[index] })
+	s.pressInput(m, key(index, "button"), control("button", index, ""), binding, now, out)
+}
+
+// Releases follow whatever the press did, not the current mapping, since the
+// active profile may have changed while it was held
+func (m *FlatMapping) releaseButton(s *Store, index uint8, now time.Time, out *resolved) {
+	if !s.releaseLayer(index) {
+		s.releaseInput(key(index, "button"), now, out)
+	}
+}
+
+// Presses the binding for the direction an axis is now pushed, releasing
+// whatever the previous direction pressed
+func (m *FlatMapping) setAxisDirection(s *Store, index uint8, dir int8, now time.Time, out *resolved) {
+	if s.lastAxis[index] == dir {
+		return
+	}
+	s.lastAxis[index] = dir
+
+	input := key(index, "axis")
+	s.releaseInput(input, now, out)
+	if dir != 0 {
+		direction := "positive"
+		if dir < 0 {
+			direction = "negative"
+		}
+		binding := m.lookup(s, func(b *FlatBindings) Binding {
+			if dir > 0 {
+				return b.AxisPos[index]
 			}
+			return b.AxisNeg[index]
+		})
+		s.pressInput(m, input, control("axis", index, direction), binding, now, out)
+	}
+}
 
-			s.lastAxis[evt.Index] = dir
+// Presses the bindings for the sector the stick is now in and the outer ring,
+// releasing whatever was pressed for the previous ones. A sector of -1 is the
+// deadzone.
+func (m *FlatMapping) setSector(s *Store, sector int, ring bool, now time.Time, out *resolved) {
+	if s.lastSector != sector {
+		s.lastSector = sector
+		input := key(StickXAxis, "sector")
+		s.releaseInput(input, now, out)
+		if sector >= 0 {
+			binding := m.lookup(s, func(b *FlatBindings) Binding { return b.SectorMap[uint8(sector)] })
+			s.pressInput(m, input, control("sector", uint8(sector), ""), binding, now, out)
 		}
 	}
 
-	return pressed, released
+	if s.ringHeld != ring {
+		s.ringHeld = ring
+		input := key(StickXAxis, "ring")
+		s.releaseInput(input, now, out)
+		if ring {
+			binding := m.lookup(s, func(b *FlatBindings) Binding { return b.SectorRing })
+			s.pressInput(m, input, "sector-ring", binding, now, out)
+		}
+	}
+}
+
+// Finds the binding for an input, checking active layers from the most
+// recently activated down to the base bindings.
+func (m *FlatMapping) lookup(s *Store, get func(b *FlatBindings) Binding) Binding {
+	for _, name := range slices.Backward(s.activeLayers) {
+		layer, ok := m.Layers[name]
+		if !ok {
+			continue
+		}
+		if binding := get(&layer.FlatBindings); binding.IsBound() {
+			return binding
+		}
+	}
+	return get(&m.FlatBindings)
 }
 
-func (m *FlatMapping) ResolveAxisKey(axis uint8, value int16) ([]KeyMapping, bool) {
-	if value > 0 {
-		key, ok := m.AxisPos[axis]
-		return key, ok
-	} else if value < 0 {
-		key, ok := m.AxisNeg[axis]
-		return key, ok
+func (m *FlatMapping) GetBinding(keyType, subKey string, index uint8) Binding {
+	if keyType != "chord" {
+		return m.FlatBindings.GetBinding(keyType, subKey, index)
+	}
+	for _, chord := range m.Chords {
+		if chord.Index == index {
+			return chord.Binding
+		}
 	}
-	return []KeyMapping{}, false
+	return Binding{}
 }
 
-func (m *FlatMapping) GetKeys(keyType, subKey string, index uint8) []KeyMapping {
-	var existingKeys []KeyMapping
+func (m *FlatBindings) GetBinding(keyType, subKey string, index uint8) Binding {
+	var binding Binding
 	switch keyType {
 	case "axis":
 		switch subKey {
 		case "positive":
-			existingKeys = m.AxisPos[index]
+			binding = m.AxisPos[index]
 		case "negative":
-			existingKeys = m.AxisNeg[index]
+			binding = m.AxisNeg[index]
 		}
 	case "hat":
-		existingKeys = m.HatDir[key(index, subKey)]
+		binding = m.HatDir[key(index, subKey)]
 	case "button":
-		existingKeys = m.ButtonMap[index]
+		binding = m.ButtonMap[index]
+	case "sector":
+		if subKey == "ring" {
+			binding = m.SectorRing
+		} else {
+			binding = m.SectorMap[index]
+		}
 	}
 
-	return existingKeys
+	return binding
 }
 
 func CompileFlatMapping(m Mapping) *FlatMapping {
 	f := &FlatMapping{
-		ButtonMap:    make(map[uint8][]KeyMapping),
-		AxisPos:      make(map[uint8][]KeyMapping),
-		AxisNeg:      make(map[uint8][]KeyMapping),
-		HatDir:       make(map[string][]KeyMapping),
-		AxisDeadzone: m.AxisDeadzone,
+		FlatBindings:       compileBindings(m.BindingSet),
+		AxisDeadzone:       m.AxisDeadzone,
+		AxisDeadzones:      m.AxisDeadzones,
+		DeadzoneShape:      m.DeadzoneShape,
+		DeadzoneHysteresis: m.DeadzoneHysteresis,
+		JoystickMode:       m.JoystickMode,
+		SectorCount:        m.SectorCount,
+		RingThreshold:      m.RingThreshold,
+		Mouse:              m.Mouse,
+		GamepadCurve:       m.GamepadCurve,
+		WheelRepeat:        wheelRepeat(m.WheelRepeatMs),
+		Layers:             make(map[string]*FlatLayer),
+		LayerButtons:       make(map[uint8]*FlatLayer),
+		ChordButtons:       make(map[uint8]bool),
+		ChordWindow:        DefaultChordWindow,
+	}
+	if m.ChordWindowMs > 0 {
+		f.ChordWindow = time.Duration(m.ChordWindowMs) * time.Millisecond
+	}
+	for i, c := range m.Chords {
+		buttons := slices.Compact(slices.Sorted(slices.Values(c.Buttons)))
+		if len(buttons) < 2 || i > math.MaxUint8 {
+			continue
+		}
+		f.Chords = append(f.Chords, FlatChord{Buttons: buttons, Binding: c.Binding, Index: uint8(i)})
+		for _, b := range buttons {
+			f.ChordButtons[b] = true
+		}
+	}
+	for name, l := range m.Layers {
+		layer := &FlatLayer{
+			FlatBindings: compileBindings(l.BindingSet),
+			Name:         name,
+			Toggle:       l.Toggle,
+		}
+		f.Layers[name] = layer
+		f.LayerButtons[l.Button] = layer
+	}
+	return f
+}
+
+func wheelRepeat(ms int) time.Duration {
+	switch {
+	case ms < 0:
+		return 0
+	case ms == 0:
+		return DefaultWheelRepeat
 	}
-	for k, v := range m.Buttons {
+	return time.Duration(ms) * time.Millisecond
+}
+
+func compileBindings(b BindingSet) FlatBindings {
+	f := FlatBindings{
+		ButtonMap:  make(map[uint8]Binding),
+		AxisPos:    make(map[uint8]Binding),
+		AxisNeg:    make(map[uint8]Binding),
+		HatDir:     make(map[string]Binding),
+		SectorMap:  make(map[uint8]Binding),
+		SectorRing: b.SectorRing,
+	}
+	for k, v := range b.Buttons {
 		f.ButtonMap[k] = v
 	}
-	for k, v := range m.Axes {
+	for k, v := range b.Axes {
 		f.AxisPos[k] = v.PositiveKey
 		f.AxisNeg[k] = v.NegativeKey
 	}
-	for k, v := range m.Hats {
+	for k, v := range b.Hats {
 		f.HatDir[key(k, "up")] = v.Up
 		f.HatDir[key(k, "down")] = v.Down
 		f.HatDir[key(k, "left")] = v.Left
 		f.HatDir[key(k, "right")] = v.Right
 	}
+	for k, v := range b.Sectors {
+		f.SectorMap[k] = v
+	}
 	return f
 }
 
 func key(i uint8, dir string) string { return fmt.Sprintf("%d_%s", i, dir) }
+
+// What the editor calls an input and direction, e.g. hat-0-up
+func control(kind string, index uint8, dir string) string {
+	if dir == "" {
+		return fmt.Sprintf("%s-%d", kind, index)
+	}
+	return fmt.Sprintf("%s-%d-%s", kind, index, dir)
+}
 func dirVal(i int16) string {
 	switch i {
 	case 1:
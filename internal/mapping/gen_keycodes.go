@@ -0,0 +1,93 @@
+//go:build ignore
+
+// Writes keycodes_kernel.go from the kernel's input-event-codes.h. Run with
+// go generate, pointing -header elsewhere if the headers live somewhere else.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// bendahl/uinput only registers keys up to KEY_MICMUTE, anything past it
+// can't be sent. Keep in step with MaxKeyCode.
+const maxKeyCode = 248
+
+var defineRegex = regexp.MustCompile(`^#define\s+(KEY_\w+)\s+(\w+)`)
+
+func main() {
+	header := flag.String("header", "/usr/include/linux/input-event-codes.h", "path to input-event-codes.h")
+	out := flag.String("o", "keycodes_kernel.go", "file to write")
+	flag.Parse()
+
+	f, err := os.Open(*header)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	type key struct {
+		name string
+		code int
+	}
+	codes := make(map[string]int)
+	var keys []key
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		match := defineRegex.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		name, value := match[1], match[2]
+		code, err := strconv.ParseInt(value, 0, 32)
+		if err != nil {
+			// aliases, like KEY_HANGUEL for KEY_HANGEUL
+			alias, ok := codes[value]
+			if !ok {
+				continue
+			}
+			code = int64(alias)
+		}
+		codes[name] = int(code)
+		switch name {
+		case "KEY_RESERVED", "KEY_MIN_INTERESTING", "KEY_MAX", "KEY_CNT":
+			continue
+		}
+		if code > 0 && code <= maxKeyCode {
+			keys = append(keys, key{name, int(code)})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	slices.SortStableFunc(keys, func(a, b key) int { return cmp.Compare(a.code, b.code) })
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "// Code generated by gen_keycodes.go from input-event-codes.h; DO NOT EDIT.")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "package mapping")
+	fmt.Fprintln(&buf)
+	fmt.Fprintf(&buf, "// The kernel's KEY_* names for every keycode up to %d\n", maxKeyCode)
+	fmt.Fprintln(&buf, "var kernelKeyCodes = map[string]int{")
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "\t%q: %d,\n", k.name, k.code)
+	}
+	fmt.Fprintln(&buf, "}")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,120 @@
+package mapping
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type HyprlandProfileSwitcher struct {
+	// directory holding the request (.socket.sock) and event (.socket2.sock)
+	// sockets
+	socketDir string
+	stores    []*Store
+}
+
+func NewHyprlandProfileSwitcher(stores []*Store) *HyprlandProfileSwitcher {
+	signature := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+
+	// older versions kept their sockets under /tmp
+	socketDir := filepath.Join("/tmp", "hypr", signature)
+	if runtime := os.Getenv("XDG_RUNTIME_DIR"); runtime != "" {
+		dir := filepath.Join(runtime, "hypr", signature)
+		if _, err := os.Stat(dir); err == nil {
+			socketDir = dir
+		}
+	}
+
+	return &HyprlandProfileSwitcher{
+		socketDir: socketDir,
+		stores:    stores,
+	}
+}
+
+func (h *HyprlandProfileSwitcher) Start(ctx context.Context) {
+	for {
+		if err := h.watch(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("hyprland: %s\n", err)
+		}
+		if !waitRetry(ctx) {
+			return
+		}
+	}
+}
+
+// Matches the window focused right now, then follows the event stream until
+// the connection drops. Hyprland resends activewindow when the focused
+// window's title changes, so that covers both.
+func (h *HyprlandProfileSwitcher) watch(ctx context.Context) error {
+	conn, err := net.Dial("unix", filepath.Join(h.socketDir, ".socket2.sock"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if win, err := h.activeWindow(); err == nil {
+		switchProfiles(h.stores, win)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fallback, ok := hyprlandEventWindow(scanner.Text())
+		if !ok {
+			continue
+		}
+		// the event doesn't include the pid, so ask for the full details
+		// and only fall back to what it does say
+		win, err := h.activeWindow()
+		if err != nil {
+			win = fallback
+		}
+		switchProfiles(h.stores, win)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// The window an event line like "activewindow>>kitty,~/src" names, if it's
+// an activewindow event
+func hyprlandEventWindow(line string) (WindowInfo, bool) {
+	event, data, ok := strings.Cut(line, ">>")
+	if !ok || event != "activewindow" {
+		return WindowInfo{}, false
+	}
+	// titles can contain commas, classes can't
+	class, title, _ := strings.Cut(data, ",")
+	return WindowInfo{Name: title, Class: class}, true
+}
+
+func (h *HyprlandProfileSwitcher) activeWindow() (WindowInfo, error) {
+	conn, err := net.Dial("unix", filepath.Join(h.socketDir, ".socket.sock"))
+	if err != nil {
+		return WindowInfo{}, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("j/activewindow")); err != nil {
+		return WindowInfo{}, err
+	}
+
+	var win struct {
+		Class string `json:"class"`
+		Title string `json:"title"`
+		PID   int    `json:"pid"`
+	}
+	if err := json.NewDecoder(conn).Decode(&win); err != nil {
+		return WindowInfo{}, err
+	}
+	return WindowInfo{Name: win.Title, Class: win.Class, PID: win.PID}, nil
+}
@@ -0,0 +1,96 @@
+package mapping
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestHyprlandEventWindow(t *testing.T) {
+	tests := []struct {
+		line string
+		want WindowInfo
+		ok   bool
+	}{
+		{"activewindow>>kitty,~/src", WindowInfo{Class: "kitty", Name: "~/src"}, true},
+		{"activewindow>>firefox,Search, results and more", WindowInfo{Class: "firefox", Name: "Search, results and more"}, true},
+		{"activewindow>>steam_app_570,", WindowInfo{Class: "steam_app_570"}, true},
+		// nothing focused
+		{"activewindow>>,", WindowInfo{}, true},
+		{"activewindowv2>>5612a0c0", WindowInfo{}, false},
+		{"workspace>>2", WindowInfo{}, false},
+		{"activewindow", WindowInfo{}, false},
+		{"", WindowInfo{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := hyprlandEventWindow(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("hyprlandEventWindow(%q) = %+v, %t, want %+v, %t", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// Answers the request socket the way Hyprland does, checking what was asked
+func fakeHyprlandRequests(t *testing.T, dir, reply string) {
+	t.Helper()
+	listener, err := net.Listen("unix", filepath.Join(dir, ".socket.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 64)
+		n, _ := conn.Read(buf)
+		if got := string(buf[:n]); got != "j/activewindow" {
+			t.Errorf("request %q, want j/activewindow", got)
+		}
+		io.WriteString(conn, reply)
+	}()
+}
+
+func TestHyprlandActiveWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    WindowInfo
+		wantErr bool
+	}{
+		{
+			name:  "window",
+			reply: `{"address": "0x5612a0c0", "class": "kitty", "title": "~/src", "pid": 4242}`,
+			want:  WindowInfo{Class: "kitty", Name: "~/src", PID: 4242},
+		},
+		{
+			name:  "nothing focused",
+			reply: `{}`,
+			want:  WindowInfo{},
+		},
+		{
+			name:    "not json",
+			reply:   "unknown request",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fakeHyprlandRequests(t, dir, tt.reply)
+
+			h := &HyprlandProfileSwitcher{socketDir: dir}
+			got, err := h.activeWindow()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("activeWindow() error = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("activeWindow() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
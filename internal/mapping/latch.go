@@ -0,0 +1,108 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Keys kept down after the input that pressed them was let go
+type latch struct {
+	keys    []KeyMapping
+	control string
+}
+
+// Toggles a binding's keys. Toggles are tracked by their keys rather than the
+// input, so stick and hat directions sharing an input latch separately and
+// any input bound to the same toggle lets it go.
+func (s *Store) toggleLatch(control string, b Binding, out *resolved) {
+	keys := b.Output()
+	id := latchID(keys)
+	if l, ok := s.latched[id]; ok {
+		delete(s.latched, id)
+		out.release(l.keys)
+	} else {
+		s.latched[id] = &latch{keys: keys, control: control}
+		out.press(keys)
+	}
+	s.publishLatched()
+}
+
+func (s *Store) pressOneShot(input, control string, b Binding, out *resolved) {
+	if l, ok := s.oneShot[input]; ok {
+		delete(s.oneShot, input)
+		out.release(l.keys)
+	} else {
+		keys := b.Output()
+		s.oneShot[input] = &latch{keys: keys, control: control}
+		out.press(keys)
+	}
+	s.publishLatched()
+}
+
+// Another input was pressed, so waiting one-shot keys are let go when it is.
+// Releasing them straight away would beat the input's own keys out.
+func (s *Store) useOneShots(input string) {
+	if len(s.oneShot) == 0 {
+		return
+	}
+	for _, l := range s.oneShot {
+		s.riding[input] = append(s.riding[input], l.keys...)
+	}
+	clear(s.oneShot)
+	s.publishLatched()
+}
+
+func (s *Store) releaseLatches(out *resolved) {
+	for _, l := range s.latched {
+		out.release(l.keys)
+	}
+	for _, l := range s.oneShot {
+		out.release(l.keys)
+	}
+	for _, keys := range s.riding {
+		out.release(keys)
+	}
+	clear(s.latched)
+	clear(s.oneShot)
+	clear(s.riding)
+	s.publishLatched()
+}
+
+// Macros are compared by their steps, since reloading the profile gives
+// them new pointers
+func latchID(keys []KeyMapping) string {
+	var id strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&id, "%d:%d,", k.Mode, k.Code)
+		if k.Macro != nil {
+			steps, _ := json.Marshal(k.Macro)
+			id.Write(steps)
+		}
+	}
+	return id.String()
+}
+
+func (s *Store) publishLatched() {
+	controls := make([]string, 0, len(s.latched)+len(s.oneShot))
+	for _, l := range s.latched {
+		controls = append(controls, l.control)
+	}
+	for _, l := range s.oneShot {
+		controls = append(controls, l.control)
+	}
+	slices.Sort(controls)
+	controls = slices.Compact(controls)
+
+	s.latchedControls.Store(&controls)
+	s.BroadcastEvent(SSEEvent{Type: EventLatched, Data: controls})
+}
+
+// Editor names of the inputs holding latched keys, e.g. button-3 or hat-0-up
+func (s *Store) LatchedControls() []string {
+	if controls := s.latchedControls.Load(); controls != nil {
+		return *controls
+	}
+	return []string{}
+}
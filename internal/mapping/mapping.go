@@ -2,7 +2,12 @@ package mapping
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
 	"os"
+	"regexp"
+	"slices"
 )
 
 type JoystickEvent struct {
@@ -22,11 +27,26 @@ type KeyMode int
 const (
 	Keyboard KeyMode = iota
 	Mouse
+	Gamepad
+	// virtual key that plays Macro for as long as it is pressed
+	MacroPlayback
 )
 
 type KeyMapping struct {
-	Code int     `json:"code"`
-	Mode KeyMode `json:"mode"`
+	Code  int     `json:"code"`
+	Mode  KeyMode `json:"mode"`
+	Macro *Macro  `json:"-"`
+}
+
+func (k KeyMapping) IsWheel() bool {
+	if k.Mode != Mouse {
+		return false
+	}
+	switch k.Code {
+	case WheelUp, WheelDown, WheelLeft, WheelRight:
+		return true
+	}
+	return false
 }
 
 func (k *KeyMapping) String() string {
@@ -35,28 +55,158 @@ func (k *KeyMapping) String() string {
 }
 
 type AxisMapping struct {
-	PositiveKey []KeyMapping `json:"positive_key"`
-	NegativeKey []KeyMapping `json:"negative_key"`
+	PositiveKey Binding `json:"positive_key"`
+	NegativeKey Binding `json:"negative_key"`
 }
 
 type HatMapping struct {
-	Up    []KeyMapping `json:"up"`
-	Down  []KeyMapping `json:"down"`
-	Left  []KeyMapping `json:"left"`
-	Right []KeyMapping `json:"right"`
+	Up    Binding `json:"up"`
+	Down  Binding `json:"down"`
+	Left  Binding `json:"left"`
+	Right Binding `json:"right"`
 }
 
 type WindowProfileCfg struct {
 	NamePattern  string `json:"name,omitempty"`
 	ClassPattern string `json:"class,omitempty"`
+	// matched against the owning process's executable path, name and
+	// command line
+	ProcessPattern  string `json:"process,omitempty"`
+	SteamAppPattern string `json:"steam_app,omitempty"`
+}
+
+// One of a profile's auto switch rules. Rules with a higher priority are
+// checked first; ties go by profile name.
+type WindowRule struct {
+	WindowProfileCfg
+	// every set pattern has to match, rather than any one of them
+	MatchAll bool `json:"match_all,omitempty"`
+	Priority int  `json:"priority,omitempty"`
+}
+
+// Checks every pattern in the rule is a valid regex
+func (c WindowProfileCfg) Validate() error {
+	var errs []error
+	for _, field := range []struct{ kind, pattern string }{
+		{"title", c.NamePattern},
+		{"class", c.ClassPattern},
+		{"process", c.ProcessPattern},
+		{"steam app", c.SteamAppPattern},
+	} {
+		if field.pattern == "" {
+			continue
+		}
+		if _, err := regexp.Compile(field.pattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s pattern: %w", field.kind, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type BindingSet struct {
+	Axes    map[uint8]AxisMapping `json:"axes,omitempty"`
+	Buttons map[uint8]Binding     `json:"buttons,omitempty"`
+	Hats    map[uint8]HatMapping  `json:"hats,omitempty"`
+	// sectors mode, keyed by sector starting from straight up and going
+	// clockwise
+	Sectors map[uint8]Binding `json:"sectors,omitempty"`
+	// pressed alongside the sector's binding while the stick is pushed
+	// (nearly) all the way
+	SectorRing Binding `json:"sector_ring,omitzero"`
+}
+
+// A named set of bindings that takes over from the base bindings while its
+// trigger button is held (or, for toggle layers, until it is pressed again).
+// Inputs without a binding on the layer fall through to the layer below.
+type Layer struct {
+	Button uint8 `json:"button"`
+	Toggle bool  `json:"toggle,omitempty"`
+	BindingSet
+}
+
+// Buttons that press a binding of their own when pressed together, instead
+// of their individual bindings
+type Chord struct {
+	Buttons []uint8 `json:"buttons"`
+	Binding Binding `json:"binding"`
+}
+
+type JoystickMode string
+
+const (
+	// stick directions press their bound keys
+	JoystickKeys JoystickMode = ""
+	// stick moves the mouse cursor
+	JoystickMouse JoystickMode = "mouse"
+	// stick is passed through to the virtual gamepad's left stick
+	JoystickGamepad JoystickMode = "gamepad"
+	// stick is split into equal slices around the centre, each pressing its
+	// own binding
+	JoystickSectors JoystickMode = "sectors"
+)
+
+// How the stick's two axes are checked against their deadzones
+type DeadzoneShape string
+
+const (
+	// keys mode uses axial, gamepad mode radial
+	DeadzoneDefault DeadzoneShape = ""
+	// each axis on its own, giving a square deadzone
+	DeadzoneAxial DeadzoneShape = "axial"
+	// both axes together, giving a circular (or elliptical) deadzone
+	DeadzoneRadial DeadzoneShape = "radial"
+)
+
+type AxisDeadzoneCfg struct {
+	// overrides the profile's deadzone for this axis
+	Inner int16 `json:"inner,omitempty"`
+	// travel past which the axis reads as fully pushed, 0 for the full range
+	Outer int16 `json:"outer,omitempty"`
+}
+
+type MouseCfg struct {
+	// cursor speed at full deflection, in pixels per pointer tick
+	Sensitivity float64 `json:"sensitivity,omitempty"`
+	// response curve exponent, 1 is linear
+	Acceleration float64 `json:"acceleration,omitempty"`
+	Deadzone     int16   `json:"deadzone,omitempty"`
 }
 
 type Mapping struct {
-	WindowProfile WindowProfileCfg       `json:"window_profiles"`
-	AxisDeadzone  int16                  `json:"axes_deadzone,omitempty"`
-	Axes          map[uint8]AxisMapping  `json:"axes,omitempty"`
-	Buttons       map[uint8][]KeyMapping `json:"buttons,omitempty"`
-	Hats          map[uint8]HatMapping   `json:"hats,omitempty"`
+	// single rule kept from before profiles could have several
+	WindowProfile WindowProfileCfg          `json:"window_profiles"`
+	WindowRules   []WindowRule              `json:"window_rules,omitempty"`
+	AxisDeadzone  int16                     `json:"axes_deadzone,omitempty"`
+	AxisDeadzones map[uint8]AxisDeadzoneCfg `json:"axis_deadzones,omitempty"`
+	DeadzoneShape DeadzoneShape             `json:"deadzone_shape,omitempty"`
+	// how far back past the deadzone the stick has to go to release a
+	// direction, so it doesn't chatter sitting on the edge
+	DeadzoneHysteresis int16        `json:"deadzone_hysteresis,omitempty"`
+	JoystickMode       JoystickMode `json:"joystick_mode,omitempty"`
+	// how many sectors the stick is split into in sectors mode, 0 for 8
+	SectorCount int `json:"sector_count,omitempty"`
+	// travel past which the outer ring binding is pressed, 0 for the default
+	RingThreshold int16    `json:"ring_threshold,omitempty"`
+	Mouse         MouseCfg `json:"mouse,omitzero"`
+	// response curve exponent for gamepad mode, 1 is linear
+	GamepadCurve float64 `json:"gamepad_curve,omitempty"`
+	// how often held wheel bindings scroll again, 0 for the default and
+	// negative to scroll once per press
+	WheelRepeatMs int `json:"wheel_repeat_ms,omitempty"`
+	BindingSet
+	Layers map[string]Layer `json:"layers,omitempty"`
+	Chords []Chord          `json:"chords,omitempty"`
+	// how long after the first of a chord's buttons the rest can be pressed,
+	// 0 for the default
+	ChordWindowMs int `json:"chord_window_ms,omitempty"`
+}
+
+// All of the profile's auto switch rules, including the older single rule
+func (m *Mapping) Rules() []WindowRule {
+	if m.WindowProfile == (WindowProfileCfg{}) {
+		return m.WindowRules
+	}
+	return append([]WindowRule{{WindowProfileCfg: m.WindowProfile}}, m.WindowRules...)
 }
 
 func (m *Mapping) LoadFromFile(path string) error {
@@ -85,12 +235,12 @@ func (m *Mapping) WriteToFile(path string) error {
 	return nil
 }
 
-func (m *Mapping) UpdateBinding(keyType, subKey string, index uint8, key []KeyMapping) {
+func (m *BindingSet) UpdateBinding(keyType, subKey string, index uint8, key Binding) {
 
 	switch keyType {
 	case "button":
 		if m.Buttons == nil {
-			m.Buttons = make(map[uint8][]KeyMapping)
+			m.Buttons = make(map[uint8]Binding)
 		}
 		m.Buttons[index] = key
 
@@ -123,11 +273,21 @@ func (m *Mapping) UpdateBinding(keyType, subKey string, index uint8, key []KeyMa
 			hat.Right = key
 		}
 		m.Hats[index] = hat
+
+	case "sector":
+		if subKey == "ring" {
+			m.SectorRing = key
+			break
+		}
+		if m.Sectors == nil {
+			m.Sectors = make(map[uint8]Binding)
+		}
+		m.Sectors[index] = key
 	}
 }
 
-func (m *Mapping) ClearBindings() {
-	key := []KeyMapping{}
+func (m *BindingSet) ClearBindings() {
+	key := Binding{Keys: []KeyMapping{}}
 	for k := range m.Axes {
 		axis := m.Axes[k]
 		axis.NegativeKey = key
@@ -145,4 +305,42 @@ func (m *Mapping) ClearBindings() {
 		hat.Right = key
 		m.Hats[k] = hat
 	}
+	for k := range m.Sectors {
+		m.Sectors[k] = key
+	}
+	m.SectorRing = Binding{}
+}
+
+// Copy of the mapping that UpdateBinding can change without touching the
+// original. Layers are still shared.
+func (m *Mapping) Clone() *Mapping {
+	c := *m
+	c.Buttons = maps.Clone(m.Buttons)
+	c.Axes = maps.Clone(m.Axes)
+	c.Hats = maps.Clone(m.Hats)
+	c.Sectors = maps.Clone(m.Sectors)
+	c.Chords = slices.Clone(m.Chords)
+	return &c
+}
+
+// Like BindingSet.UpdateBinding, but also reaches chords, by their position
+func (m *Mapping) UpdateBinding(keyType, subKey string, index uint8, key Binding) {
+	if keyType != "chord" {
+		m.BindingSet.UpdateBinding(keyType, subKey, index, key)
+		return
+	}
+	if int(index) < len(m.Chords) {
+		m.Chords[index].Binding = key
+	}
+}
+
+func (m *Mapping) ClearBindings() {
+	m.BindingSet.ClearBindings()
+	for i := range m.Chords {
+		m.Chords[i].Binding = Binding{Keys: []KeyMapping{}}
+	}
+	for name, layer := range m.Layers {
+		layer.ClearBindings()
+		m.Layers[name] = layer
+	}
 }
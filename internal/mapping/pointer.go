@@ -0,0 +1,113 @@
+package mapping
+
+import (
+	"math"
+	"time"
+)
+
+// How often the cursor is moved while the stick is in mouse mode
+const PointerInterval = 8 * time.Millisecond
+
+const (
+	StickXAxis uint8 = 0
+	StickYAxis uint8 = 1
+
+	defaultMouseSensitivity  = 12
+	defaultMouseAcceleration = 2
+	defaultMouseDeadzone     = 3000
+)
+
+func isStickAxis(index uint8) bool {
+	return index == StickXAxis || index == StickYAxis
+}
+
+// Converts a stick position into cursor movement for a single pointer tick.
+// The deadzone is radial and the remaining travel is rescaled so movement
+// starts from zero at its edge.
+func (c MouseCfg) Motion(x, y int16) (float64, float64) {
+	sensitivity := c.Sensitivity
+	if sensitivity <= 0 {
+		sensitivity = defaultMouseSensitivity
+	}
+	acceleration := c.Acceleration
+	if acceleration <= 0 {
+		acceleration = defaultMouseAcceleration
+	}
+	deadzone := float64(c.Deadzone)
+	if c.Deadzone <= 0 {
+		deadzone = defaultMouseDeadzone
+	}
+	deadzone /= math.MaxInt16
+
+	nx := float64(x) / math.MaxInt16
+	ny := float64(y) / math.MaxInt16
+	magnitude := math.Hypot(nx, ny)
+	if magnitude <= deadzone {
+		return 0, 0
+	}
+
+	scaled := min((magnitude-deadzone)/(1-deadzone), 1)
+	speed := sensitivity * math.Pow(scaled, acceleration)
+	return speed * nx / magnitude, speed * ny / magnitude
+}
+
+// Returns whole pixels to move the cursor by for this tick, or zero when the
+// active profile isn't in mouse mode. Must be called from the same goroutine
+// as Resolve.
+// Whether the stick is pushed far enough to move the cursor, so the pointer
+// only needs ticking while it is
+func (s *Store) PointerActive() bool {
+	m := s.ActiveMapping.Load()
+	if m == nil || m.JoystickMode != JoystickMouse {
+		return false
+	}
+	dx, dy := m.Mouse.Motion(s.axisValue[StickXAxis], s.axisValue[StickYAxis])
+	return dx != 0 || dy != 0
+}
+
+func (s *Store) PointerMotion() (int32, int32) {
+	m := s.ActiveMapping.Load()
+	if m == nil || m.JoystickMode != JoystickMouse {
+		s.pointerRemX, s.pointerRemY = 0, 0
+		return 0, 0
+	}
+
+	dx, dy := m.Mouse.Motion(s.axisValue[StickXAxis], s.axisValue[StickYAxis])
+	if dx == 0 && dy == 0 {
+		s.pointerRemX, s.pointerRemY = 0, 0
+		return 0, 0
+	}
+
+	dx += s.pointerRemX
+	dy += s.pointerRemY
+	moveX, moveY := math.Trunc(dx), math.Trunc(dy)
+	s.pointerRemX, s.pointerRemY = dx-moveX, dy-moveY
+
+	return int32(moveX), int32(moveY)
+}
+
+// Applies the profile's deadzones and response curve to the stick for the
+// virtual gamepad. The remaining travel is rescaled, so the output still spans
+// the full range.
+func (m *FlatMapping) GamepadStick(x, y int16) (int16, int16) {
+	curve := m.GamepadCurve
+	if curve <= 0 {
+		curve = 1
+	}
+
+	sx, sy := m.scaleStick(x, y, curve)
+	outX := math.Round(sx * math.MaxInt16)
+	outY := math.Round(sy * math.MaxInt16)
+	return int16(max(min(outX, math.MaxInt16), -math.MaxInt16)), int16(max(min(outY, math.MaxInt16), -math.MaxInt16))
+}
+
+// Returns the gamepad stick position, or false when the active profile isn't
+// passing the stick through to the gamepad.
+func (s *Store) GamepadStick() (int16, int16, bool) {
+	m := s.ActiveMapping.Load()
+	if m == nil || m.JoystickMode != JoystickGamepad {
+		return 0, 0, false
+	}
+	x, y := m.GamepadStick(s.axisValue[StickXAxis], s.axisValue[StickYAxis])
+	return x, y, true
+}
@@ -0,0 +1,94 @@
+package mapping
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The process that owns a window, as far as /proc will tell us
+type ProcessInfo struct {
+	Exe     string
+	Comm    string
+	Cmdline string
+	// Steam app id of the game the process belongs to, if any
+	SteamAppID string
+}
+
+var steamClassRegex = regexp.MustCompile(`^steam_app_(\d+)$`)
+var steamLaunchRegex = regexp.MustCompile(`\bAppId=(\d+)\b`)
+
+// How far up the process tree to look for the Steam launcher
+const steamAncestorDepth = 8
+
+// Reads what it can about pid. Processes owned by other users hide some of
+// this, so missing fields are left blank rather than treated as errors.
+func readProcessInfo(pid int, class string) ProcessInfo {
+	var info ProcessInfo
+	if pid > 0 {
+		dir := filepath.Join("/proc", strconv.Itoa(pid))
+		info.Exe, _ = os.Readlink(filepath.Join(dir, "exe"))
+		if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+			info.Comm = strings.TrimSpace(string(comm))
+		}
+		if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+			info.Cmdline = strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})))
+		}
+	}
+	info.SteamAppID = steamAppID(pid, class)
+	return info
+}
+
+// Proton windows usually carry the id in their class. Otherwise Steam sets
+// it in the game's environment, and its launcher has it on the command line.
+func steamAppID(pid int, class string) string {
+	if m := steamClassRegex.FindStringSubmatch(class); m != nil {
+		return m[1]
+	}
+
+	for range steamAncestorDepth {
+		if pid <= 1 {
+			break
+		}
+		dir := filepath.Join("/proc", strconv.Itoa(pid))
+
+		if environ, err := os.ReadFile(filepath.Join(dir, "environ")); err == nil {
+			for kv := range bytes.SplitSeq(environ, []byte{0}) {
+				key, value, _ := strings.Cut(string(kv), "=")
+				if (key == "SteamAppId" || key == "SteamGameId") && value != "" && value != "0" {
+					return value
+				}
+			}
+		}
+		if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+			if m := steamLaunchRegex.FindSubmatch(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})); m != nil {
+				return string(m[1])
+			}
+		}
+
+		pid = parentPID(dir)
+	}
+	return ""
+}
+
+func parentPID(procDir string) int {
+	stat, err := os.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return 0
+	}
+	// comm is in parentheses and may itself contain spaces or parentheses
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0
+	}
+	var state byte
+	var ppid int
+	if _, err := fmt.Sscanf(string(stat[end+1:]), " %c %d", &state, &ppid); err != nil {
+		return 0
+	}
+	return ppid
+}
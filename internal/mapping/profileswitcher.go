@@ -0,0 +1,122 @@
+package mapping
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"time"
+)
+
+// Watches which window has focus and switches profiles to match
+type ProfileSwitcher interface {
+	// Runs until ctx is cancelled (blocking)
+	Start(ctx context.Context)
+}
+
+// What window rules are matched against
+type WindowInfo struct {
+	Name  string
+	Class string
+	// owning process, 0 if unknown
+	PID     int
+	Process ProcessInfo
+}
+
+// Picks a backend for the current session. Wayland has no common way to
+// see the focused window, so only compositors with their own IPC work there.
+func NewProfileSwitcher(stores []*Store, pollPeriod time.Duration) (ProfileSwitcher, error) {
+	if _, ok := os.LookupEnv("SWAYSOCK"); ok {
+		return NewSwayProfileSwitcher(stores), nil
+	}
+	if _, ok := os.LookupEnv("HYPRLAND_INSTANCE_SIGNATURE"); ok {
+		return NewHyprlandProfileSwitcher(stores), nil
+	}
+	if _, ok := os.LookupEnv("WAYLAND_DISPLAY"); ok {
+		return nil, errors.New("unsupported wayland compositor")
+	}
+	return NewAutoProfileSwitcher(stores, pollPeriod)
+}
+
+// How long to wait before reconnecting to a compositor that went away
+const switcherRetryInterval = 5 * time.Second
+
+// Waits out the retry interval, returning false if ctx was cancelled first
+func waitRetry(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(switcherRetryInterval):
+		return true
+	}
+}
+
+func switchProfiles(stores []*Store, win WindowInfo) {
+	win.Process = readProcessInfo(win.PID, win.Class)
+	for _, store := range stores {
+		store.FocusedWindow.Store(&win)
+		switchStoreProfile(store, win)
+	}
+}
+
+func switchStoreProfile(store *Store, win WindowInfo) {
+	var metadata Metadata
+	if m := store.Metadata.Load(); m != nil {
+		metadata = *m
+	}
+	if metadata.PinnedProfile != "" {
+		return
+	}
+
+	target := metadata.FallbackProfile
+	if wp, ok := store.MatchWindow(win); ok {
+		target = wp.Profile
+	}
+
+	// title changes fire often, so don't rewrite the active link for every
+	// one
+	if target != "" && store.ActiveProfile.Load() != target {
+		if err := store.SetActiveProfile(target); err != nil {
+			log.Printf("auto switch: %s\n", err)
+		}
+	}
+}
+
+// The first rule, in priority order, that matches win
+func (s *Store) MatchWindow(win WindowInfo) (WindowProfile, bool) {
+	for _, wp := range *s.WindowProfiles.Load() {
+		if wp.Matches(win) {
+			return wp, true
+		}
+	}
+	return WindowProfile{}, false
+}
+
+func (wp *WindowProfile) Matches(win WindowInfo) bool {
+	results := make([]bool, 0, 4)
+	if wp.NameRegex != nil {
+		results = append(results, wp.NameRegex.MatchString(win.Name))
+	}
+	if wp.ClassRegex != nil {
+		results = append(results, wp.ClassRegex.MatchString(win.Class))
+	}
+	if wp.ProcessRegex != nil {
+		p := win.Process
+		results = append(results, slices.ContainsFunc([]string{p.Exe, p.Comm, p.Cmdline}, func(field string) bool {
+			return field != "" && wp.ProcessRegex.MatchString(field)
+		}))
+	}
+	if wp.SteamAppRegex != nil {
+		id := win.Process.SteamAppID
+		results = append(results, id != "" && wp.SteamAppRegex.MatchString(id))
+	}
+
+	if len(results) == 0 {
+		return false
+	}
+	if wp.MatchAll {
+		return !slices.Contains(results, false)
+	}
+	return slices.Contains(results, true)
+}
@@ -0,0 +1,68 @@
+package mapping
+
+import "math"
+
+const (
+	DefaultSectorCount = 8
+	MaxSectorCount     = 32
+	// fraction of the outer deadzone past which the outer ring is pressed,
+	// when no threshold is set
+	defaultRingFraction = 0.9
+	// how far into the next sector the stick has to go before switching, so
+	// it doesn't flicker on the boundary. Narrow sectors get a quarter of
+	// their width instead.
+	sectorMargin = 5 * math.Pi / 180
+)
+
+// Number of sectors the stick is split into, with the default filled in
+func (m *Mapping) NumSectors() int { return sectorCount(m.SectorCount) }
+
+func sectorCount(n int) int {
+	if n <= 0 {
+		return DefaultSectorCount
+	}
+	return min(n, MaxSectorCount)
+}
+
+// Which sector the stick is in, or -1 inside the deadzone, and whether it's
+// past the outer ring, given where it was last. Sector 0 is centred on
+// straight up and the rest follow clockwise.
+func (m *FlatMapping) stickSector(x, y int16, prev int, prevRing bool) (int, bool) {
+	fx, fy := float64(x), float64(y)
+	magnitude := math.Hypot(fx, fy)
+	if magnitude == 0 {
+		return -1, false
+	}
+	dx, dy := fx/magnitude, fy/magnitude
+	hysteresis := float64(m.DeadzoneHysteresis)
+
+	innerX, outerX := m.axisDeadzone(StickXAxis)
+	innerY, outerY := m.axisDeadzone(StickYAxis)
+	inner := ellipseRadius(innerX, innerY, dx, dy)
+	if prev >= 0 {
+		if magnitude <= max(inner-hysteresis, 0) {
+			return -1, false
+		}
+	} else if magnitude < inner {
+		return -1, false
+	}
+
+	count := sectorCount(m.SectorCount)
+	width := 2 * math.Pi / float64(count)
+	// y grows downwards, so this is clockwise from straight up
+	angle := math.Atan2(dx, -dy)
+	sector := int(math.Floor(math.Mod(angle+width/2+2*math.Pi, 2*math.Pi)/width)) % count
+	if prev >= 0 && prev < count && sector != prev {
+		fromCentre := math.Abs(math.Remainder(angle-float64(prev)*width, 2*math.Pi))
+		if fromCentre <= width/2+min(sectorMargin, width/4) {
+			sector = prev
+		}
+	}
+
+	ringAt := defaultRingFraction * ellipseRadius(outerX, outerY, dx, dy)
+	if m.RingThreshold > 0 {
+		ringAt = float64(m.RingThreshold)
+	}
+	ring := magnitude >= ringAt || (prevRing && magnitude > ringAt-hysteresis)
+	return sector, ring
+}
@@ -0,0 +1,327 @@
+package mapping
+
+import (
+	"slices"
+	"time"
+)
+
+// Taps are sent as a press followed by a release this much later, so games
+// that poll input don't miss them. Macro taps are held as long by default.
+const TapDuration = 20 * time.Millisecond
+
+const DefaultWheelRepeat = 100 * time.Millisecond
+
+// Keys the resolver has decided to press or release, applied together
+type resolved struct {
+	pressed  []KeyMapping
+	released []KeyMapping
+}
+
+func (r *resolved) press(keys []KeyMapping)   { r.pressed = append(r.pressed, keys...) }
+func (r *resolved) release(keys []KeyMapping) { r.released = append(r.released, keys...) }
+
+// A tap-hold input that is down but not yet decided either way
+type pendingHold struct {
+	binding  Binding
+	deadline time.Time
+}
+
+// Keys pressed again on an interval while their input is held
+type repeatingKeys struct {
+	keys     []KeyMapping
+	interval time.Duration
+	next     time.Time
+}
+
+// Keys going down and up on a cycle while their input is held
+type turboKeys struct {
+	keys  []KeyMapping
+	turbo *TurboMapping
+	down  bool
+	next  time.Time
+	// profile switches before it started
+	switchGen uint64
+}
+
+type scheduledRelease struct {
+	at   time.Time
+	keys []KeyMapping
+}
+
+// control is the editor's name for the input and direction, for showing
+// which ones have latched keys
+func (s *Store) pressInput(m *FlatMapping, input, control string, b Binding, now time.Time, out *resolved) {
+	// chord buttons still waiting were pressed first, so they go out first
+	if len(s.chordBuffer) > 0 {
+		m.flushChord(s, now, out)
+	}
+	s.resolvePermissive(m, now, out)
+
+	if b.Latch == LatchOneShot {
+		s.pressOneShot(input, control, b, out)
+		return
+	}
+	s.useOneShots(input)
+	if b.Latch == LatchToggle {
+		s.toggleLatch(control, b, out)
+		return
+	}
+
+	if b.Hold != nil && isBound(b.Hold.Keys) {
+		s.pending[input] = &pendingHold{
+			binding:  b,
+			deadline: now.Add(b.Hold.Timeout()),
+		}
+		return
+	}
+
+	s.holdKeys(m, input, b.Output(), b.Turbo, now, out)
+}
+
+// Presses keys until input is released. Keys are recorded against the
+// physical input that pressed them, so the release always mirrors the press
+// even if the active layer changed.
+func (s *Store) holdKeys(m *FlatMapping, input string, keys []KeyMapping, turbo *TurboMapping, now time.Time, out *resolved) {
+	if turbo != nil {
+		// macros can't be pressed more than once, so only they stay held
+		var cycled, held []KeyMapping
+		for _, k := range keys {
+			if k.Mode == MacroPlayback {
+				held = append(held, k)
+			} else {
+				cycled = append(cycled, k)
+			}
+		}
+		if len(cycled) > 0 {
+			s.turbo[input] = &turboKeys{
+				keys:      cycled,
+				turbo:     turbo,
+				down:      true,
+				next:      now.Add(turbo.interval()),
+				switchGen: s.switchGen.Load(),
+			}
+			out.press(cycled)
+		}
+		keys = held
+	}
+
+	s.held[input] = keys
+	out.press(keys)
+	s.startWheelRepeat(m, input, keys, now)
+}
+
+// Wheel keys only scroll one notch per press, so keep scrolling while held
+func (s *Store) startWheelRepeat(m *FlatMapping, input string, keys []KeyMapping, now time.Time) {
+	if m.WheelRepeat <= 0 {
+		return
+	}
+
+	var wheel []KeyMapping
+	for _, k := range keys {
+		if k.IsWheel() {
+			wheel = append(wheel, k)
+		}
+	}
+	if len(wheel) == 0 {
+		return
+	}
+
+	s.repeating[input] = &repeatingKeys{
+		keys:     wheel,
+		interval: m.WheelRepeat,
+		next:     now.Add(m.WheelRepeat),
+	}
+}
+
+func (s *Store) releaseInput(input string, now time.Time, out *resolved) {
+	oneShots := s.riding[input]
+	delete(s.riding, input)
+
+	if p, ok := s.pending[input]; ok {
+		delete(s.pending, input)
+		keys := p.binding.Output()
+		out.press(keys)
+		s.scheduled = append(s.scheduled, scheduledRelease{
+			at:   now.Add(TapDuration),
+			keys: append(slices.Clone(keys), oneShots...),
+		})
+		return
+	}
+
+	if t, ok := s.turbo[input]; ok {
+		delete(s.turbo, input)
+		if t.down {
+			out.release(t.keys)
+		}
+	}
+	out.release(s.held[input])
+	out.release(oneShots)
+	delete(s.held, input)
+	delete(s.repeating, input)
+}
+
+// Lets go of an input a tap's length from now rather than straight away, for
+// when its press is only being sent in the same batch
+func (s *Store) releaseInputLater(input string, now time.Time, out *resolved) {
+	var later resolved
+	s.releaseInput(input, now, &later)
+	out.press(later.pressed)
+	if len(later.released) > 0 {
+		s.scheduled = append(s.scheduled, scheduledRelease{
+			at:   now.Add(TapDuration),
+			keys: later.released,
+		})
+	}
+}
+
+func (s *Store) commitHold(m *FlatMapping, input string, p *pendingHold, now time.Time, out *resolved) {
+	delete(s.pending, input)
+	s.holdKeys(m, input, p.binding.Hold.Keys, p.binding.Turbo, now, out)
+}
+
+// Another input was pressed, so any permissive tap-holds become holds
+func (s *Store) resolvePermissive(m *FlatMapping, now time.Time, out *resolved) {
+	for input, p := range s.pending {
+		if p.binding.Hold.Permissive {
+			s.commitHold(m, input, p, now, out)
+		}
+	}
+}
+
+// Processes anything that was waiting on time rather than on an input event.
+// Must be called from the same goroutine as Resolve.
+func (s *Store) Tick(now time.Time) ([]KeyMapping, []KeyMapping) {
+	var out resolved
+
+	m := s.ActiveMapping.Load()
+	if m == nil {
+		m = &FlatMapping{}
+	}
+
+	if len(s.chordBuffer) > 0 && !now.Before(s.chordDeadline) {
+		m.resolveChord(s, now, &out)
+	}
+
+	for input, p := range s.pending {
+		if !now.Before(p.deadline) {
+			s.commitHold(m, input, p, now, &out)
+		}
+	}
+
+	for _, r := range s.repeating {
+		if !now.Before(r.next) {
+			out.press(r.keys)
+			r.next = now.Add(r.interval)
+		}
+	}
+
+	for _, t := range s.turbo {
+		if now.Before(t.next) {
+			continue
+		}
+		if t.down {
+			out.release(t.keys)
+		} else {
+			out.press(t.keys)
+		}
+		t.down = !t.down
+		t.next = now.Add(t.turbo.interval())
+	}
+
+	remaining := s.scheduled[:0]
+	for _, r := range s.scheduled {
+		if now.Before(r.at) {
+			remaining = append(remaining, r)
+			continue
+		}
+		out.release(r.keys)
+	}
+	s.scheduled = remaining
+
+	return out.pressed, out.released
+}
+
+// Returns when Tick next needs to run, if anything is waiting.
+func (s *Store) NextDeadline() (time.Time, bool) {
+	var next time.Time
+	consider := func(t time.Time) {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+
+	if len(s.chordBuffer) > 0 {
+		consider(s.chordDeadline)
+	}
+	for _, p := range s.pending {
+		consider(p.deadline)
+	}
+	for _, r := range s.scheduled {
+		consider(r.at)
+	}
+	for _, r := range s.repeating {
+		consider(r.next)
+	}
+	for _, t := range s.turbo {
+		consider(t.next)
+	}
+
+	return next, !next.IsZero()
+}
+
+// Lets go of everything the device was holding, for when its inputs can no
+// longer be trusted to send their releases (e.g. it was unplugged). Toggle
+// layers stay on since they don't depend on a button being held.
+func (s *Store) ReleaseAll() []KeyMapping {
+	var out resolved
+
+	for input, keys := range s.held {
+		out.release(keys)
+		delete(s.held, input)
+	}
+	for _, r := range s.scheduled {
+		out.release(r.keys)
+	}
+	s.scheduled = nil
+	s.stopTurbo(&out, func(*turboKeys) bool { return true })
+	s.releaseLatches(&out)
+	s.chordBuffer = nil
+	clear(s.chordMembers)
+	clear(s.pending)
+	clear(s.repeating)
+
+	clear(s.lastHat)
+	clear(s.lastAxis)
+	clear(s.axisValue)
+	s.lastSector, s.ringHeld = -1, false
+	s.pointerRemX, s.pointerRemY = 0, 0
+
+	for button := range s.layerButtons {
+		s.releaseLayer(button)
+	}
+
+	return out.released
+}
+
+// Stops cycling the turbo keys that match, letting go of any that are down.
+// The rest of their input's keys stay held until it is released.
+func (s *Store) stopTurbo(out *resolved, match func(t *turboKeys) bool) {
+	for input, t := range s.turbo {
+		if !match(t) {
+			continue
+		}
+		if t.down {
+			out.release(t.keys)
+		}
+		delete(s.turbo, input)
+	}
+}
+
+// Stops turbo keys started before the last profile switch, even if it
+// switched back since. Must be called from the same goroutine as Resolve.
+func (s *Store) StopOtherTurbo() []KeyMapping {
+	var out resolved
+	gen := s.switchGen.Load()
+	s.stopTurbo(&out, func(t *turboKeys) bool { return t.switchGen != gen })
+	return out.released
+}
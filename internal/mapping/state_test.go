@@ -0,0 +1,104 @@
+package mapping
+
+import (
+	"testing"
+	"time"
+)
+
+// Keeps track of what the virtual devices would have held down, the same way
+// output.Writer applies a batch: releases first, then presses
+type fakeWriter struct {
+	held    map[KeyMapping]bool
+	pressed map[KeyMapping]bool
+}
+
+func newFakeWriter() *fakeWriter {
+	return &fakeWriter{held: make(map[KeyMapping]bool), pressed: make(map[KeyMapping]bool)}
+}
+
+func (w *fakeWriter) Apply(press, release []KeyMapping) {
+	for _, k := range release {
+		delete(w.held, k)
+	}
+	for _, k := range press {
+		w.held[k] = true
+		w.pressed[k] = true
+	}
+}
+
+func newTestStore(t *testing.T, profiles map[string]Mapping) *Store {
+	t.Helper()
+	s := NewStore(t.TempDir(), 0)
+	flat := make(map[string]*FlatMapping)
+	for name, m := range profiles {
+		flat[name] = CompileFlatMapping(m)
+	}
+	s.Mappings.Store(&flat)
+	return s
+}
+
+func buttonBinding(code int) BindingSet {
+	return BindingSet{Buttons: map[uint8]Binding{0: {Keys: []KeyMapping{{Code: code, Mode: Keyboard}}}}}
+}
+
+func TestReleaseAfterProfileSwitch(t *testing.T) {
+	keyA := KeyMapping{Code: 30, Mode: Keyboard}
+	keyB := KeyMapping{Code: 48, Mode: Keyboard}
+	s := newTestStore(t, map[string]Mapping{
+		"a": {BindingSet: buttonBinding(keyA.Code)},
+		"b": {BindingSet: buttonBinding(keyB.Code)},
+	})
+	w := newFakeWriter()
+	now := time.Now()
+
+	s.setActive("a")
+	w.Apply(s.ActiveMapping.Load().Resolve(s, JoystickEvent{Type: "button", Index: 0, Value: 1}, now))
+	if !w.held[keyA] {
+		t.Fatalf("profile a's key not pressed, held %v", w.held)
+	}
+
+	s.setActive("b")
+	w.Apply(s.ActiveMapping.Load().Resolve(s, JoystickEvent{Type: "button", Index: 0, Value: 0}, now.Add(time.Second)))
+	// anything released a tap later only shows up on the next tick
+	w.Apply(s.Tick(now.Add(2 * time.Second)))
+
+	if w.held[keyA] {
+		t.Errorf("profile a's key still held after the button was released on profile b")
+	}
+	if w.pressed[keyB] {
+		t.Errorf("profile b's key was pressed by a release")
+	}
+	if len(w.held) != 0 {
+		t.Errorf("keys left held: %v", w.held)
+	}
+}
+
+func TestTurboStopsAfterSwitchingBack(t *testing.T) {
+	key := KeyMapping{Code: 30, Mode: Keyboard}
+	turbo := BindingSet{Buttons: map[uint8]Binding{0: {Keys: []KeyMapping{key}, Turbo: &TurboMapping{}}}}
+	s := newTestStore(t, map[string]Mapping{
+		"a": {BindingSet: turbo},
+		"b": {},
+	})
+	w := newFakeWriter()
+	now := time.Now()
+
+	s.setActive("a")
+	w.Apply(s.ActiveMapping.Load().Resolve(s, JoystickEvent{Type: "button", Index: 0, Value: 1}, now))
+	if !w.held[key] {
+		t.Fatalf("turbo key not pressed, held %v", w.held)
+	}
+
+	// both switches land before the event loop gets to either
+	s.setActive("b")
+	s.setActive("a")
+	<-s.Switched()
+	w.Apply(nil, s.StopOtherTurbo())
+
+	for i := 1; i <= 10; i++ {
+		w.Apply(s.Tick(now.Add(time.Duration(i) * time.Second)))
+	}
+	if w.held[key] || len(s.turbo) != 0 {
+		t.Errorf("turbo still running after switching away and back, held %v", w.held)
+	}
+}
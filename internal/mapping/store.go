@@ -1,12 +1,14 @@
 package mapping
 
 import (
+	"cmp"
 	"context"
 	"embed"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
@@ -14,6 +16,7 @@ import (
 	"slices"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
@@ -22,18 +25,107 @@ type Profile struct {
 	Name     string
 	Active   bool
 	Selected bool
+	Pinned   bool
 }
 
+// A compiled auto switch rule
 type WindowProfile struct {
-	NameRegex  *regexp.Regexp
-	ClassRegex *regexp.Regexp
-	Profile    string
+	NameRegex     *regexp.Regexp
+	ClassRegex    *regexp.Regexp
+	ProcessRegex  *regexp.Regexp
+	SteamAppRegex *regexp.Regexp
+	MatchAll      bool
+	Priority      int
+	Profile       string
+	// position among the profile's rules, for showing which one matched
+	Index int
+}
+
+// Compiles a profile's window rules, skipping any rule with a pattern that
+// doesn't compile or no patterns at all.
+func compileWindowProfiles(name string, m *Mapping) []WindowProfile {
+	var compiled []WindowProfile
+	for i, rule := range m.Rules() {
+		if rule.WindowProfileCfg == (WindowProfileCfg{}) {
+			continue
+		}
+		wp, err := CompileWindowRule(name, i, rule)
+		if err != nil {
+			log.Printf("skipping rule %d of %s: %v\n", i+1, name, err)
+			continue
+		}
+		compiled = append(compiled, wp)
+	}
+	return compiled
+}
+
+// Compiles a single auto switch rule belonging to profile
+func CompileWindowRule(profile string, index int, rule WindowRule) (WindowProfile, error) {
+	if err := rule.Validate(); err != nil {
+		return WindowProfile{}, err
+	}
+	return WindowProfile{
+		NameRegex:     compilePattern(rule.NamePattern),
+		ClassRegex:    compilePattern(rule.ClassPattern),
+		ProcessRegex:  compilePattern(rule.ProcessPattern),
+		SteamAppRegex: compilePattern(rule.SteamAppPattern),
+		MatchAll:      rule.MatchAll,
+		Priority:      rule.Priority,
+		Profile:       profile,
+		Index:         index,
+	}, nil
+}
+
+// Only for patterns that have already been validated
+func compilePattern(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// A saved rule that was skipped because one of its patterns is invalid
+type RuleError struct {
+	Profile string
+	Index   int
+	Err     error
+}
+
+func (s *Store) InvalidRules() []RuleError {
+	var invalid []RuleError
+	rawMappings := *s.RawMappings.Load()
+	for _, name := range slices.Sorted(maps.Keys(rawMappings)) {
+		for i, rule := range rawMappings[name].Rules() {
+			if err := rule.Validate(); err != nil {
+				invalid = append(invalid, RuleError{Profile: name, Index: i, Err: err})
+			}
+		}
+	}
+	return invalid
+}
+
+// Puts rules in the order they're checked
+func sortWindowProfiles(profiles []WindowProfile) {
+	slices.SortStableFunc(profiles, func(a, b WindowProfile) int {
+		if a.Priority != b.Priority {
+			return cmp.Compare(b.Priority, a.Priority)
+		}
+		if c := strings.Compare(strings.ToLower(a.Profile), strings.ToLower(b.Profile)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Index, b.Index)
+	})
 }
 
 type Metadata struct {
 	IsOppositeHand  bool `json:"opposite_hand"`
 	ExclusiveAccess bool `json:"exclusive_access"`
 	InvertAxes      bool `json:"invert_axes"`
+	VirtualGamepad  bool `json:"virtual_gamepad"`
+	// activated when the focused window matches no rule
+	FallbackProfile string `json:"fallback_profile,omitempty"`
+	// set while auto switching is suspended in favour of this profile
+	PinnedProfile string `json:"pinned_profile,omitempty"`
 }
 
 type Store struct {
@@ -44,6 +136,10 @@ type Store struct {
 	WindowProfiles atomic.Pointer[[]WindowProfile]
 	// name of active profile
 	ActiveProfile atomic.Value
+	// whether the physical device is currently plugged in
+	Connected atomic.Bool
+	// last window the profile switcher saw focused, nil until it sees one
+	FocusedWindow atomic.Pointer[WindowInfo]
 
 	DevicePath  string
 	ProfilePath string
@@ -52,20 +148,69 @@ type Store struct {
 	activePath string
 	lastHat    map[uint8]int16
 	lastAxis   map[uint8]int8
-	eventSubs  atomic.Pointer[map[*chan SSEEvent]struct{}]
+	// sector the stick is in for sectors mode, -1 while it's centred
+	lastSector int
+	ringHeld   bool
+	// raw position of each axis, for modes that use the stick directly
+	axisValue map[uint8]int16
+	// sub-pixel cursor movement carried over to the next pointer tick
+	pointerRemX float64
+	pointerRemY float64
+	// output keys currently held down, keyed by the physical input
+	held      map[string][]KeyMapping
+	pending   map[string]*pendingHold
+	repeating map[string]*repeatingKeys
+	turbo     map[string]*turboKeys
+	scheduled []scheduledRelease
+	// keys latched by toggle bindings, by latchID
+	latched map[string]*latch
+	// keys from one-shot bindings waiting on the next input, by their input
+	oneShot map[string]*latch
+	// one-shot keys let go along with the input that used them
+	riding map[string][]KeyMapping
+	// chord buttons pressed but not yet resolved, in the order pressed
+	chordBuffer   []uint8
+	chordDeadline time.Time
+	// buttons held as part of a chord, to the chord's input
+	chordMembers map[uint8]string
+	// editor names of the inputs with latched keys, for the web interface
+	latchedControls atomic.Pointer[[]string]
+	// active layer names, most recently activated last
+	activeLayers []string
+	// momentary layers by the button holding them on
+	layerButtons map[uint8]string
+	eventSubs    atomic.Pointer[map[*chan SSEEvent]struct{}]
+	// signalled when a different profile becomes active
+	switched chan struct{}
+	// counts profile switches, so switching away and back before the event
+	// loop notices still counts
+	switchGen atomic.Uint64
 }
 
 func NewStore(profilesPath string, productID uint16) *Store {
 	s := Store{
-		DevicePath:  path.Dir(profilesPath),
-		ProfilePath: profilesPath,
-		activePath:  filepath.Join(profilesPath, "active"),
-		ProductID:   productID,
-		lastHat:     make(map[uint8]int16),
-		lastAxis:    make(map[uint8]int8),
+		DevicePath:   path.Dir(profilesPath),
+		ProfilePath:  profilesPath,
+		activePath:   filepath.Join(profilesPath, "active"),
+		ProductID:    productID,
+		lastHat:      make(map[uint8]int16),
+		lastAxis:     make(map[uint8]int8),
+		lastSector:   -1,
+		axisValue:    make(map[uint8]int16),
+		held:         make(map[string][]KeyMapping),
+		pending:      make(map[string]*pendingHold),
+		repeating:    make(map[string]*repeatingKeys),
+		turbo:        make(map[string]*turboKeys),
+		latched:      make(map[string]*latch),
+		oneShot:      make(map[string]*latch),
+		riding:       make(map[string][]KeyMapping),
+		chordMembers: make(map[uint8]string),
+		layerButtons: make(map[uint8]string),
+		switched:     make(chan struct{}, 1),
 	}
 
 	s.eventSubs.Store(&map[*chan SSEEvent]struct{}{})
+	s.Connected.Store(true)
 
 	return &s
 }
@@ -75,10 +220,12 @@ func (s *Store) ListProfiles() []Profile {
 
 	mappings := s.Mappings.Load()
 	active := s.ActiveProfile.Load()
+	pinned := s.PinnedProfile()
 	for name := range *mappings {
 		prof := Profile{
 			Name:   name,
 			Active: name == active,
+			Pinned: name == pinned,
 		}
 		out = append(out, prof)
 	}
@@ -126,19 +273,9 @@ func (s *Store) ReloadAllProfiles() error {
 		rawMappings[name] = &m
 		mappings[name] = CompileFlatMapping(m)
 
-		var compiled WindowProfile
-		if m.WindowProfile.NamePattern != "" {
-			compiled.NameRegex = regexp.MustCompile(m.WindowProfile.NamePattern)
-		}
-		if m.WindowProfile.ClassPattern != "" {
-			compiled.ClassRegex = regexp.MustCompile(m.WindowProfile.ClassPattern)
-		}
-		if compiled.NameRegex != nil || compiled.ClassRegex != nil {
-			compiled.Profile = name
-			windowProfiles = append(windowProfiles, compiled)
-		}
-
+		windowProfiles = append(windowProfiles, compileWindowProfiles(name, &m)...)
 	}
+	sortWindowProfiles(windowProfiles)
 
 	s.Mappings.Store(&mappings)
 	s.RawMappings.Store(&rawMappings)
@@ -203,26 +340,8 @@ func (s *Store) ReloadProfile(name string) error {
 	windowProfiles = filtered
 
 	// Add any new matchers defined in this profile
-	def := m.WindowProfile
-	var compiled WindowProfile
-	if def.NamePattern != "" {
-		if r, err := regexp.Compile(def.NamePattern); err == nil {
-			compiled.NameRegex = r
-		} else {
-			fmt.Printf("invalid name regex for %s: %v\n", name, err)
-		}
-	}
-	if def.ClassPattern != "" {
-		if r, err := regexp.Compile(def.ClassPattern); err == nil {
-			compiled.ClassRegex = r
-		} else {
-			fmt.Printf("invalid class regex for %s: %v\n", name, err)
-		}
-	}
-	if compiled.NameRegex != nil || compiled.ClassRegex != nil {
-		compiled.Profile = name
-		windowProfiles = append(windowProfiles, compiled)
-	}
+	windowProfiles = append(windowProfiles, compileWindowProfiles(name, &m)...)
+	sortWindowProfiles(windowProfiles)
 
 	// Atomically store back
 	s.Mappings.Store(&mappings)
@@ -358,6 +477,99 @@ func (s *Store) CreateNewProfile(fileName string) error {
 	return nil
 }
 
+// Strips characters that don't belong in a profile's file name
+func CleanProfileName(name string) string {
+	name = strings.ReplaceAll(name, ".", "")
+	name = strings.ReplaceAll(name, "/", "")
+	return strings.TrimSpace(name)
+}
+
+func (s *Store) ProfileExists(name string) bool {
+	_, ok := (*s.RawMappings.Load())[name]
+	return ok
+}
+
+func (s *Store) DuplicateProfile(name, newName string) error {
+	if !s.ProfileExists(name) {
+		return fmt.Errorf("profile %s not found", name)
+	}
+	if s.ProfileExists(newName) {
+		return fmt.Errorf("profile %s already exists", newName)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.ProfilePath, name+".json"))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.ProfilePath, newName+".json"), data, 0755); err != nil {
+		return err
+	}
+	return s.ReloadProfile(newName)
+}
+
+// Renames a profile, keeping it active, pinned or the fallback if it was
+func (s *Store) RenameProfile(name, newName string) error {
+	if !s.ProfileExists(name) {
+		return fmt.Errorf("profile %s not found", name)
+	}
+	if s.ProfileExists(newName) {
+		return fmt.Errorf("profile %s already exists", newName)
+	}
+
+	err := os.Rename(filepath.Join(s.ProfilePath, name+".json"), filepath.Join(s.ProfilePath, newName+".json"))
+	if err != nil {
+		return err
+	}
+	// the watcher doesn't follow renames
+	s.RemoveProfile(name)
+	if err := s.ReloadProfile(newName); err != nil {
+		return err
+	}
+
+	if s.ActiveProfile.Load() == name {
+		if err := s.SetActiveProfile(newName); err != nil {
+			return err
+		}
+	}
+	metadata := s.Metadata.Load()
+	if metadata != nil && (metadata.PinnedProfile == name || metadata.FallbackProfile == name) {
+		return s.updateMetadata(func(m *Metadata) {
+			if m.PinnedProfile == name {
+				m.PinnedProfile = newName
+			}
+			if m.FallbackProfile == name {
+				m.FallbackProfile = newName
+			}
+		})
+	}
+	return nil
+}
+
+func (s *Store) DeleteProfile(name string) error {
+	if !s.ProfileExists(name) {
+		return fmt.Errorf("profile %s not found", name)
+	}
+	if err := os.Remove(filepath.Join(s.ProfilePath, name+".json")); err != nil {
+		return err
+	}
+	s.RemoveProfile(name)
+	return nil
+}
+
+// Replaces a profile's mapping, saving it and applying it straight away
+func (s *Store) WriteMapping(name string, m Mapping) error {
+	if err := m.WriteToFile(filepath.Join(s.ProfilePath, name+".json")); err != nil {
+		return err
+	}
+	if err := s.ReloadProfile(name); err != nil {
+		return err
+	}
+	if s.ActiveProfile.Load() == name {
+		s.setActive(name)
+	}
+	return nil
+}
+
 func (s *Store) SetActiveProfile(name string) error {
 	target := filepath.Join(s.ProfilePath, name+".json")
 	if _, err := os.Stat(target); os.IsNotExist(err) {
@@ -386,19 +598,62 @@ func (s *Store) SetActiveProfile(name string) error {
 	return nil
 }
 
+// Activates a profile and suspends auto switching until it's unpinned
+func (s *Store) PinProfile(name string) error {
+	if err := s.SetActiveProfile(name); err != nil {
+		return err
+	}
+	return s.updateMetadata(func(m *Metadata) { m.PinnedProfile = name })
+}
+
+func (s *Store) UnpinProfile() error {
+	return s.updateMetadata(func(m *Metadata) { m.PinnedProfile = "" })
+}
+
+// The pinned profile, or "" if none is pinned or the metadata isn't loaded
+func (s *Store) PinnedProfile() string {
+	if metadata := s.Metadata.Load(); metadata != nil {
+		return metadata.PinnedProfile
+	}
+	return ""
+}
+
+// Activates a profile by hand. If a profile is pinned the pin moves with
+// it, so auto switching stays off until the user unpins.
+func (s *Store) ActivateProfile(name string) error {
+	if s.PinnedProfile() != "" {
+		return s.PinProfile(name)
+	}
+	return s.SetActiveProfile(name)
+}
+
+func (s *Store) updateMetadata(update func(m *Metadata)) error {
+	var metadata Metadata
+	if current := s.Metadata.Load(); current != nil {
+		metadata = *current
+	}
+	update(&metadata)
+	s.Metadata.Store(&metadata)
+	return s.SaveMetadata()
+}
+
 func (s *Store) setActive(name string) {
-	s.ActiveProfile.Store(name)
+	previous, _ := s.ActiveProfile.Swap(name).(string)
 
-	mappingsPtr := s.Mappings.Load()
-	if mappingsPtr == nil {
-		s.ActiveMapping.Store(nil)
-		return
+	var flat *FlatMapping
+	if mappingsPtr := s.Mappings.Load(); mappingsPtr != nil {
+		flat = (*mappingsPtr)[name]
 	}
+	s.ActiveMapping.Store(flat)
 
-	if flat, ok := (*mappingsPtr)[name]; ok {
-		s.ActiveMapping.Store(flat)
-	} else {
-		s.ActiveMapping.Store(nil)
+	// signalled once the new mapping is in place, so the event loop never
+	// sees the switch but the old mapping
+	if previous != name {
+		s.switchGen.Add(1)
+		select {
+		case s.switched <- struct{}{}:
+		default:
+		}
 	}
 }
 
@@ -410,36 +665,24 @@ func (s *Store) migrateProfile(newMapping Mapping, oldData []byte) error {
 
 	for i, axe := range oldMapping.Axes {
 		newMapping.Axes[i] = AxisMapping{
-			PositiveKey: []KeyMapping{
-				{Code: axe.PositiveKey.Code, Mode: KeyMode(axe.PositiveKey.Mode)},
-			},
-			NegativeKey: []KeyMapping{
-				{Code: axe.NegativeKey.Code, Mode: KeyMode(axe.NegativeKey.Mode)},
-			},
+			PositiveKey: Binding{Keys: []KeyMapping{{Code: axe.PositiveKey.Code, Mode: KeyMode(axe.PositiveKey.Mode)}}},
+			NegativeKey: Binding{Keys: []KeyMapping{{Code: axe.NegativeKey.Code, Mode: KeyMode(axe.NegativeKey.Mode)}}},
 		}
 	}
 
 	for i, hat := range oldMapping.Hats {
 		newMapping.Hats[i] = HatMapping{
-			Up: []KeyMapping{
-				{Code: hat.Up.Code, Mode: KeyMode(hat.Up.Mode)},
-			},
-			Down: []KeyMapping{
-				{Code: hat.Down.Code, Mode: KeyMode(hat.Down.Mode)},
-			},
-			Left: []KeyMapping{
-				{Code: hat.Left.Code, Mode: KeyMode(hat.Left.Mode)},
-			},
-			Right: []KeyMapping{
-				{Code: hat.Right.Code, Mode: KeyMode(hat.Right.Mode)},
-			},
+			Up:    Binding{Keys: []KeyMapping{{Code: hat.Up.Code, Mode: KeyMode(hat.Up.Mode)}}},
+			Down:  Binding{Keys: []KeyMapping{{Code: hat.Down.Code, Mode: KeyMode(hat.Down.Mode)}}},
+			Left:  Binding{Keys: []KeyMapping{{Code: hat.Left.Code, Mode: KeyMode(hat.Left.Mode)}}},
+			Right: Binding{Keys: []KeyMapping{{Code: hat.Right.Code, Mode: KeyMode(hat.Right.Mode)}}},
 		}
 	}
 
 	for i, key := range oldMapping.Buttons {
-		newMapping.Buttons[i] = []KeyMapping{
+		newMapping.Buttons[i] = Binding{Keys: []KeyMapping{
 			{Code: key.Code, Mode: KeyMode(key.Mode)},
-		}
+		}}
 	}
 
 	return nil
@@ -461,12 +704,49 @@ func (s *Store) ReloadActive() error {
 	return nil
 }
 
+// Receives when a different profile has been activated since the last
+// receive, so the event loop can stop anything tied to the old one
+func (s *Store) Switched() <-chan struct{} { return s.switched }
+
 func (s *Store) Resolve(evt JoystickEvent) ([]KeyMapping, []KeyMapping) {
 	m := s.ActiveMapping.Load()
 	if m == nil {
 		return nil, nil
 	}
-	return m.Resolve(s, evt)
+	return m.Resolve(s, evt, time.Now())
+}
+
+// Activates a layer from its trigger button. Momentary layers remember the
+// button so its release turns the layer back off, even if the profile was
+// switched in between.
+func (s *Store) pressLayer(button uint8, layer *FlatLayer) {
+	active := slices.Contains(s.activeLayers, layer.Name)
+	s.removeLayer(layer.Name)
+	if layer.Toggle {
+		if !active {
+			s.activeLayers = append(s.activeLayers, layer.Name)
+		}
+		return
+	}
+	s.activeLayers = append(s.activeLayers, layer.Name)
+	s.layerButtons[button] = layer.Name
+}
+
+// Returns false if the button wasn't holding a layer on
+func (s *Store) releaseLayer(button uint8) bool {
+	name, ok := s.layerButtons[button]
+	if !ok {
+		return false
+	}
+	delete(s.layerButtons, button)
+	s.removeLayer(name)
+	return true
+}
+
+func (s *Store) removeLayer(name string) {
+	s.activeLayers = slices.DeleteFunc(s.activeLayers, func(n string) bool {
+		return n == name
+	})
 }
 
 func GetDeviceFromID(productID uint16) (string, error) {
@@ -540,8 +820,19 @@ const (
 	EventJoystick        EventType = "joystick"
 	EventActiveProfile   EventType = "activeProfile"
 	EventSelectedProfile EventType = "selectedProfile"
+	EventDevice          EventType = "device"
+	EventLatched         EventType = "latched"
 )
 
+type DeviceStatus struct {
+	Connected bool `json:"connected"`
+}
+
+func (s *Store) SetConnected(connected bool) {
+	s.Connected.Store(connected)
+	s.BroadcastEvent(SSEEvent{Type: EventDevice, Data: DeviceStatus{Connected: connected}})
+}
+
 type SSEEvent struct {
 	Type EventType `json:"type"`
 	Data any       `json:"data"`
@@ -0,0 +1,175 @@
+package mapping
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+)
+
+// i3 IPC, which sway implements: a magic string, then the payload length and
+// message type as native-endian uint32s, then the JSON payload.
+const (
+	swayMagic         = "i3-ipc"
+	swaySubscribe     = 2
+	swayGetTree       = 4
+	swayWindowEvent   = 0x80000003
+	swayHeaderLength  = len(swayMagic) + 8
+	swayMaxPayloadLen = 64 << 20
+)
+
+type swayNode struct {
+	Name             string `json:"name"`
+	Focused          bool   `json:"focused"`
+	AppID            string `json:"app_id"`
+	PID              int    `json:"pid"`
+	WindowProperties struct {
+		Class string `json:"class"`
+	} `json:"window_properties"`
+	Nodes         []swayNode `json:"nodes"`
+	FloatingNodes []swayNode `json:"floating_nodes"`
+}
+
+// Native wayland windows have an app_id, xwayland ones a class
+func (n *swayNode) window() WindowInfo {
+	class := n.AppID
+	if class == "" {
+		class = n.WindowProperties.Class
+	}
+	return WindowInfo{Name: n.Name, Class: class, PID: n.PID}
+}
+
+func (n *swayNode) focused() *swayNode {
+	if n.Focused {
+		return n
+	}
+	for _, children := range [][]swayNode{n.Nodes, n.FloatingNodes} {
+		for i := range children {
+			if f := children[i].focused(); f != nil {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+type swayWindowChange struct {
+	Change    string   `json:"change"`
+	Container swayNode `json:"container"`
+}
+
+type SwayProfileSwitcher struct {
+	socketPath string
+	stores     []*Store
+}
+
+func NewSwayProfileSwitcher(stores []*Store) *SwayProfileSwitcher {
+	return &SwayProfileSwitcher{
+		socketPath: os.Getenv("SWAYSOCK"),
+		stores:     stores,
+	}
+}
+
+func (s *SwayProfileSwitcher) Start(ctx context.Context) {
+	for {
+		if err := s.watch(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("sway: %s\n", err)
+		}
+		if !waitRetry(ctx) {
+			return
+		}
+	}
+}
+
+// Matches the window focused right now, then follows focus and title changes
+// until the connection drops.
+func (s *SwayProfileSwitcher) watch(ctx context.Context) error {
+	conn, err := net.Dial("unix", s.socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := swayWrite(conn, swayGetTree, nil); err != nil {
+		return err
+	}
+	_, payload, err := swayRead(conn)
+	if err != nil {
+		return err
+	}
+	var tree swayNode
+	if err := json.Unmarshal(payload, &tree); err != nil {
+		return fmt.Errorf("parsing tree: %w", err)
+	}
+	if win := tree.focused(); win != nil {
+		switchProfiles(s.stores, win.window())
+	}
+
+	if err := swayWrite(conn, swaySubscribe, []byte(`["window"]`)); err != nil {
+		return err
+	}
+
+	for {
+		msgType, payload, err := swayRead(conn)
+		if err != nil {
+			return err
+		}
+		if msgType != swayWindowEvent {
+			continue
+		}
+
+		var evt swayWindowChange
+		if err := json.Unmarshal(payload, &evt); err != nil {
+			continue
+		}
+		switch evt.Change {
+		case "focus":
+		case "title":
+			if !evt.Container.Focused {
+				continue
+			}
+		default:
+			continue
+		}
+		switchProfiles(s.stores, evt.Container.window())
+	}
+}
+
+func swayWrite(w io.Writer, msgType uint32, payload []byte) error {
+	buf := bytes.NewBufferString(swayMagic)
+	binary.Write(buf, binary.NativeEndian, uint32(len(payload)))
+	binary.Write(buf, binary.NativeEndian, msgType)
+	buf.Write(payload)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func swayRead(r io.Reader) (uint32, []byte, error) {
+	header := make([]byte, swayHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	if string(header[:len(swayMagic)]) != swayMagic {
+		return 0, nil, fmt.Errorf("bad ipc magic %q", header[:len(swayMagic)])
+	}
+
+	length := binary.NativeEndian.Uint32(header[len(swayMagic):])
+	msgType := binary.NativeEndian.Uint32(header[len(swayMagic)+4:])
+	if length > swayMaxPayloadLen {
+		return 0, nil, fmt.Errorf("ipc message too large (%d bytes)", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return msgType, payload, nil
+}
@@ -0,0 +1,119 @@
+package mapping
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func swayFrame(magic string, length, msgType uint32, payload string) []byte {
+	buf := bytes.NewBufferString(magic)
+	binary.Write(buf, binary.NativeEndian, length)
+	binary.Write(buf, binary.NativeEndian, msgType)
+	buf.WriteString(payload)
+	return buf.Bytes()
+}
+
+func TestSwayRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		msgType uint32
+		payload string
+	}{
+		{"get tree", swayGetTree, ""},
+		{"subscribe", swaySubscribe, `["window"]`},
+		{"window event", swayWindowEvent, `{"change":"focus","container":{"name":"kitty","focused":true}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			go func() {
+				if err := swayWrite(client, tt.msgType, []byte(tt.payload)); err != nil {
+					t.Errorf("swayWrite: %s", err)
+				}
+			}()
+			msgType, payload, err := swayRead(server)
+			if err != nil {
+				t.Fatalf("swayRead: %s", err)
+			}
+			if msgType != tt.msgType || string(payload) != tt.payload {
+				t.Errorf("read %#x %q, want %#x %q", msgType, payload, tt.msgType, tt.payload)
+			}
+		})
+	}
+}
+
+func TestSwayWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := swayWrite(&buf, swaySubscribe, []byte(`["window"]`)); err != nil {
+		t.Fatal(err)
+	}
+	want := swayFrame(swayMagic, 10, swaySubscribe, `["window"]`)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("wrote %q, want %q", buf.Bytes(), want)
+	}
+}
+
+func TestSwayRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   []byte
+		msgType uint32
+		payload string
+		wantErr string
+	}{
+		{
+			name:    "event",
+			frame:   swayFrame(swayMagic, 2, swayWindowEvent, "{}"),
+			msgType: swayWindowEvent,
+			payload: "{}",
+		},
+		{
+			name:    "empty payload",
+			frame:   swayFrame(swayMagic, 0, swayGetTree, ""),
+			msgType: swayGetTree,
+		},
+		{
+			name:    "bad magic",
+			frame:   swayFrame("i4-ipc", 2, swayGetTree, "{}"),
+			wantErr: "bad ipc magic",
+		},
+		{
+			name:    "too large",
+			frame:   swayFrame(swayMagic, swayMaxPayloadLen+1, swayGetTree, ""),
+			wantErr: "too large",
+		},
+		{
+			name:    "short header",
+			frame:   []byte(swayMagic),
+			wantErr: "unexpected EOF",
+		},
+		{
+			name:    "short payload",
+			frame:   swayFrame(swayMagic, 10, swayGetTree, "{}"),
+			wantErr: "unexpected EOF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgType, payload, err := swayRead(bytes.NewReader(tt.frame))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("swayRead error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("swayRead: %s", err)
+			}
+			if msgType != tt.msgType || string(payload) != tt.payload {
+				t.Errorf("read %#x %q, want %#x %q", msgType, payload, tt.msgType, tt.payload)
+			}
+		})
+	}
+}
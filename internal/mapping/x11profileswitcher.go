@@ -3,6 +3,7 @@ package mapping
 import (
 	"bytes"
 	"context"
+	"errors"
 	"log"
 	"time"
 
@@ -11,27 +12,149 @@ import (
 )
 
 type AutoProfileSwitcher struct {
-	conn       *xgb.Conn
-	store      *Store
+	conn *xgb.Conn
+	// EWMH atoms, interned up front
+	netActiveWindow xproto.Atom
+	netWmName       xproto.Atom
+	netWmPid        xproto.Atom
+	// each device follows its own rules
+	stores     []*Store
 	pollPeriod time.Duration
 }
 
-func NewAutoProfileSwitcher(store *Store, pollPeriod time.Duration) (*AutoProfileSwitcher, error) {
+func NewAutoProfileSwitcher(stores []*Store, pollPeriod time.Duration) (*AutoProfileSwitcher, error) {
 	conn, err := xgb.NewConn()
 	if err != nil {
 		return nil, err
 	}
 
-	return &AutoProfileSwitcher{
+	a := &AutoProfileSwitcher{
 		conn:       conn,
-		store:      store,
+		stores:     stores,
 		pollPeriod: pollPeriod,
-	}, nil
+	}
+	if a.netActiveWindow, err = a.atom("_NET_ACTIVE_WINDOW"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if a.netWmName, err = a.atom("_NET_WM_NAME"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if a.netWmPid, err = a.atom("_NET_WM_PID"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return a, nil
 }
 
-// Runs the polling loop (blocking)
+// Follows focus through EWMH property events, falling back to polling for
+// window managers that don't set _NET_ACTIVE_WINDOW (blocking)
 func (a *AutoProfileSwitcher) Start(ctx context.Context) {
+	defer a.conn.Close()
+
+	root := xproto.Setup(a.conn).DefaultScreen(a.conn).Root
+	if err := a.watchActiveWindow(ctx, root); err != nil {
+		log.Printf("Active window events unavailable, polling instead: %s\n", err)
+		a.poll(ctx)
+	}
+}
+
+func (a *AutoProfileSwitcher) watchActiveWindow(ctx context.Context, root xproto.Window) error {
+	// A WM that supports it always has the property set on the root window
+	if _, err := a.getActiveWindow(root); err != nil {
+		return err
+	}
+
+	err := xproto.ChangeWindowAttributesChecked(a.conn, root, xproto.CwEventMask,
+		[]uint32{xproto.EventMaskPropertyChange}).Check()
+	if err != nil {
+		return err
+	}
+
+	events := make(chan xgb.Event)
+	go func() {
+		defer close(events)
+		for {
+			evt, err := a.conn.WaitForEvent()
+			if evt == nil && err == nil {
+				// connection closed
+				return
+			}
+			if evt == nil {
+				continue
+			}
+			select {
+			case events <- evt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var active xproto.Window
+	// Watches the newly active window for title changes
+	focus := func() {
+		win, err := a.getActiveWindow(root)
+		if err != nil || win == active {
+			return
+		}
+		if active != 0 {
+			xproto.ChangeWindowAttributes(a.conn, active, xproto.CwEventMask, []uint32{0})
+		}
+		active = win
+		if active == 0 {
+			return
+		}
+		xproto.ChangeWindowAttributes(a.conn, active, xproto.CwEventMask,
+			[]uint32{xproto.EventMaskPropertyChange})
+		a.switchProfileForWindow(active)
+	}
+	focus()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case evt, ok := <-events:
+			if !ok {
+				return nil
+			}
+			notify, ok := evt.(xproto.PropertyNotifyEvent)
+			if !ok {
+				continue
+			}
+			switch {
+			case notify.Window == root && notify.Atom == a.netActiveWindow:
+				focus()
+			case notify.Window == active && (notify.Atom == xproto.AtomWmName || notify.Atom == a.netWmName):
+				a.switchProfileForWindow(active)
+			}
+		}
+	}
+}
 
+func (a *AutoProfileSwitcher) atom(name string) (xproto.Atom, error) {
+	reply, err := xproto.InternAtom(a.conn, false, uint16(len(name)), name).Reply()
+	if err != nil {
+		return 0, err
+	}
+	return reply.Atom, nil
+}
+
+func (a *AutoProfileSwitcher) getActiveWindow(root xproto.Window) (xproto.Window, error) {
+	prop, err := xproto.GetProperty(a.conn, false, root, a.netActiveWindow, xproto.AtomWindow, 0, 1).Reply()
+	if err != nil {
+		return 0, err
+	}
+	if prop.Format != 32 || len(prop.Value) < 4 {
+		return 0, errors.New("window manager does not set _NET_ACTIVE_WINDOW")
+	}
+	return xproto.Window(xgb.Get32(prop.Value)), nil
+}
+
+// Polls the input focus for window managers without EWMH (blocking)
+func (a *AutoProfileSwitcher) poll(ctx context.Context) {
 	setup := xproto.Setup(a.conn)
 	screen := setup.DefaultScreen(a.conn)
 	rootWin := screen.Root
@@ -83,8 +206,6 @@ func (a *AutoProfileSwitcher) getFocusedWindow() (xproto.Window, error) {
 
 // Checks window properties and updates the active profile.
 func (a *AutoProfileSwitcher) switchProfileForWindow(win xproto.Window) {
-	store := a.store
-
 	name, err := a.getWindowName(win)
 	if err != nil {
 		log.Printf("switchProfileForWindow: %s\n", err)
@@ -96,27 +217,31 @@ func (a *AutoProfileSwitcher) switchProfileForWindow(win xproto.Window) {
 		return
 	}
 
-	windowProfiles := *store.WindowProfiles.Load()
-	for _, wp := range windowProfiles {
-		match := false
+	// not every client sets a pid, so rules just won't see a process
+	pid, _ := a.getWindowPID(win)
 
-		if wp.NameRegex != nil && wp.NameRegex.MatchString(name) {
-			match = true
-		}
-
-		if wp.ClassRegex != nil && wp.ClassRegex.MatchString(class) {
-			match = true
-		}
+	switchProfiles(a.stores, WindowInfo{Name: name, Class: class, PID: pid})
+}
 
-		if match {
-			store.SetActiveProfile(wp.Profile)
-			return
-		}
+func (a *AutoProfileSwitcher) getWindowPID(win xproto.Window) (int, error) {
+	prop, err := xproto.GetProperty(a.conn, false, win, a.netWmPid, xproto.AtomCardinal, 0, 1).Reply()
+	if err != nil {
+		return 0, err
 	}
+	if prop.Format != 32 || len(prop.Value) < 4 {
+		return 0, errors.New("window has no _NET_WM_PID")
+	}
+	return int(xgb.Get32(prop.Value)), nil
 }
 
+// Prefers the UTF-8 _NET_WM_NAME, which modern clients keep up to date
 func (a *AutoProfileSwitcher) getWindowName(win xproto.Window) (string, error) {
-	prop, err := xproto.GetProperty(a.conn, false, win, xproto.AtomWmName, xproto.GetPropertyTypeAny, 0, (1<<32)-1).Reply()
+	prop, err := xproto.GetProperty(a.conn, false, win, a.netWmName, xproto.GetPropertyTypeAny, 0, (1<<32)-1).Reply()
+	if err == nil && prop != nil && len(prop.Value) > 0 {
+		return string(prop.Value), nil
+	}
+
+	prop, err = xproto.GetProperty(a.conn, false, win, xproto.AtomWmName, xproto.GetPropertyTypeAny, 0, (1<<32)-1).Reply()
 	if err != nil || prop == nil {
 		return "", err
 	}
@@ -0,0 +1,56 @@
+package output
+
+import (
+	"github.com/caedis/noreza/internal/mapping"
+)
+
+const (
+	absX     = 0x00
+	absY     = 0x01
+	absZ     = 0x02
+	absRX    = 0x03
+	absRY    = 0x04
+	absRZ    = 0x05
+	absHat0X = 0x10
+	absHat0Y = 0x11
+)
+
+type gamepadDevice struct {
+	*virtualDevice
+}
+
+// Creates a gamepad with an Xbox 360 controller's layout and ids, so games
+// and SDL pick it up as a standard controller.
+func createGamepad(path string, name string) (*gamepadDevice, error) {
+	keys := make([]uint16, 0, len(mapping.GamepadToCode))
+	for _, code := range mapping.GamepadToCode {
+		keys = append(keys, uint16(code))
+	}
+
+	stick := absRange{min: -32768, max: 32767}
+	trigger := absRange{min: 0, max: 255}
+	hat := absRange{min: -1, max: 1}
+
+	dev, err := createDevice(path, deviceSpec{
+		name: name,
+		id:   inputID{Bustype: busUSB, Vendor: 0x045e, Product: 0x028e, Version: 1},
+		keys: keys,
+		abs: map[uint16]absRange{
+			absX: stick, absY: stick,
+			absRX: stick, absRY: stick,
+			absZ: trigger, absRZ: trigger,
+			absHat0X: hat, absHat0Y: hat,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &gamepadDevice{dev}, nil
+}
+
+func (g *gamepadDevice) Stick(x, y int16) error {
+	return g.emit(
+		inputEvent{Type: evAbs, Code: absX, Value: int32(x)},
+		inputEvent{Type: evAbs, Code: absY, Value: int32(y)},
+	)
+}
@@ -0,0 +1,147 @@
+package output
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/caedis/noreza/internal/mapping"
+)
+
+const minRepeatInterval = 10 * time.Millisecond
+
+// Plays macros on their own goroutines so a long sequence never holds up the
+// event loop.
+type macroPlayer struct {
+	writer *Writer
+
+	mu      sync.Mutex
+	running map[*mapping.Macro]*playback
+}
+
+type playback struct {
+	cancel context.CancelFunc
+	// closed once the macro has stopped and released its keys
+	done chan struct{}
+	// cleared when the input is released, ends repeat macros
+	held bool
+}
+
+func newMacroPlayer(w *Writer) *macroPlayer {
+	return &macroPlayer{
+		writer:  w,
+		running: make(map[*mapping.Macro]*playback),
+	}
+}
+
+func (p *macroPlayer) press(macro *mapping.Macro) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pb, ok := p.running[macro]; ok {
+		// already playing, don't start it twice
+		pb.held = true
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pb := &playback{cancel: cancel, done: make(chan struct{}), held: true}
+	p.running[macro] = pb
+	go p.play(ctx, macro, pb)
+}
+
+func (p *macroPlayer) release(macro *mapping.Macro) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pb, ok := p.running[macro]
+	if !ok {
+		return
+	}
+	pb.held = false
+	if macro.Mode == mapping.MacroCancel {
+		pb.cancel()
+	}
+}
+
+func (p *macroPlayer) stopAll() {
+	p.mu.Lock()
+	var waiting []chan struct{}
+	for _, pb := range p.running {
+		pb.cancel()
+		waiting = append(waiting, pb.done)
+	}
+	p.mu.Unlock()
+
+	for _, done := range waiting {
+		<-done
+	}
+}
+
+func (p *macroPlayer) isHeld(pb *playback) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return pb.held
+}
+
+func (p *macroPlayer) play(ctx context.Context, macro *mapping.Macro, pb *playback) {
+	// keys the macro pressed but has not released yet
+	down := make(map[mapping.KeyMapping]struct{})
+
+	defer func() {
+		for key := range down {
+			p.writer.keyUp(key)
+		}
+
+		p.mu.Lock()
+		delete(p.running, macro)
+		p.mu.Unlock()
+		close(pb.done)
+	}()
+
+	for {
+		for _, step := range macro.Steps {
+			if ctx.Err() != nil {
+				return
+			}
+
+			switch step.Action {
+			case mapping.MacroTap:
+				// held for a moment like any other tap, zero length presses
+				// get dropped by some games
+				p.writer.keyDown(step.Key)
+				down[step.Key] = struct{}{}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(step.TapHold()):
+				}
+				p.writer.keyUp(step.Key)
+				delete(down, step.Key)
+			case mapping.MacroDown:
+				p.writer.keyDown(step.Key)
+				down[step.Key] = struct{}{}
+			case mapping.MacroUp:
+				p.writer.keyUp(step.Key)
+				delete(down, step.Key)
+			case mapping.MacroWait:
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Duration(step.DelayMs) * time.Millisecond):
+				}
+			}
+		}
+
+		if macro.Mode != mapping.MacroRepeat || !p.isHeld(pb) {
+			return
+		}
+
+		// keeps a repeat macro without any waits from spinning
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(minRepeatInterval):
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package output
+
+const (
+	BtnLeft   = 0x110
+	BtnRight  = 0x111
+	BtnMiddle = 0x112
+	BtnSide   = 0x113
+	BtnExtra  = 0x114
+
+	relX      = 0x00
+	relY      = 0x01
+	relHWheel = 0x06
+	relWheel  = 0x08
+)
+
+type mouseDevice struct {
+	*virtualDevice
+}
+
+func createMouse(path string, name string) (*mouseDevice, error) {
+	dev, err := createDevice(path, deviceSpec{
+		name: name,
+		id:   inputID{Bustype: busUSB, Vendor: 0x4711, Product: 0x0816, Version: 1},
+		keys: []uint16{BtnLeft, BtnRight, BtnMiddle, BtnSide, BtnExtra},
+		rels: []uint16{relX, relY, relHWheel, relWheel},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mouseDevice{dev}, nil
+}
+
+func (m *mouseDevice) Move(dx, dy int32) error {
+	return m.emit(
+		inputEvent{Type: evRel, Code: relX, Value: dx},
+		inputEvent{Type: evRel, Code: relY, Value: dy},
+	)
+}
+
+func (m *mouseDevice) Wheel(code uint16, delta int32) error {
+	return m.emit(inputEvent{Type: evRel, Code: code, Value: delta})
+}
@@ -0,0 +1,169 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Minimal uinput device setup. The uinput package's mouse only registers
+// left, right and middle, and its gamepad never sets axis ranges, so those
+// devices are created here directly.
+
+const (
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetRelBit  = 0x40045566
+	uiSetAbsBit  = 0x40045567
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+
+	evSyn     = 0x00
+	evKey     = 0x01
+	evRel     = 0x02
+	evAbs     = 0x03
+	synReport = 0
+
+	busUSB = 0x03
+)
+
+type inputID struct {
+	Bustype uint16
+	Vendor  uint16
+	Product uint16
+	Version uint16
+}
+
+// struct uinput_user_dev
+type uinputUserDev struct {
+	Name         [80]byte
+	ID           inputID
+	FFEffectsMax uint32
+	AbsMax       [64]int32
+	AbsMin       [64]int32
+	AbsFuzz      [64]int32
+	AbsFlat      [64]int32
+}
+
+// struct input_event
+type inputEvent struct {
+	Time  unix.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+type absRange struct {
+	min, max int32
+}
+
+type deviceSpec struct {
+	name string
+	id   inputID
+	keys []uint16
+	rels []uint16
+	abs  map[uint16]absRange
+}
+
+type virtualDevice struct {
+	file *os.File
+}
+
+func createDevice(path string, spec deviceSpec) (*virtualDevice, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|unix.O_NONBLOCK, 0660)
+	if err != nil {
+		return nil, fmt.Errorf("could not open uinput: %w", err)
+	}
+	fd := int(file.Fd())
+
+	register := func(req uint, value uint16) error {
+		if err := unix.IoctlSetInt(fd, req, int(value)); err != nil {
+			return fmt.Errorf("could not register %s event %#x: %w", spec.name, value, err)
+		}
+		return nil
+	}
+
+	dev := uinputUserDev{ID: spec.id}
+	copy(dev.Name[:len(dev.Name)-1], spec.name)
+
+	var setupErr error
+	if len(spec.keys) > 0 {
+		setupErr = register(uiSetEvBit, evKey)
+		for _, code := range spec.keys {
+			setupErr = firstErr(setupErr, register(uiSetKeyBit, code))
+		}
+	}
+	if len(spec.rels) > 0 {
+		setupErr = firstErr(setupErr, register(uiSetEvBit, evRel))
+		for _, code := range spec.rels {
+			setupErr = firstErr(setupErr, register(uiSetRelBit, code))
+		}
+	}
+	if len(spec.abs) > 0 {
+		setupErr = firstErr(setupErr, register(uiSetEvBit, evAbs))
+		for code, r := range spec.abs {
+			setupErr = firstErr(setupErr, register(uiSetAbsBit, code))
+			dev.AbsMin[code] = r.min
+			dev.AbsMax[code] = r.max
+		}
+	}
+	if setupErr != nil {
+		file.Close()
+		return nil, setupErr
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.NativeEndian, dev); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not set up %s: %w", spec.name, err)
+	}
+	if err := unix.IoctlSetInt(fd, uiDevCreate, 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not create %s: %w", spec.name, err)
+	}
+
+	// give udev a moment to pick the device up before it's used
+	time.Sleep(200 * time.Millisecond)
+
+	return &virtualDevice{file: file}, nil
+}
+
+func firstErr(a, b error) error {
+	if a != nil {
+		return a
+	}
+	return b
+}
+
+func (d *virtualDevice) Close() error {
+	unix.IoctlSetInt(int(d.file.Fd()), uiDevDestroy, 0)
+	return d.file.Close()
+}
+
+// Writes the events followed by a sync report
+func (d *virtualDevice) emit(events ...inputEvent) error {
+	var buf bytes.Buffer
+	for _, evt := range append(events, inputEvent{Type: evSyn, Code: synReport}) {
+		if err := binary.Write(&buf, binary.NativeEndian, evt); err != nil {
+			return err
+		}
+	}
+	_, err := d.file.Write(buf.Bytes())
+	return err
+}
+
+func (d *virtualDevice) Button(code uint16, pressed bool) error {
+	var value int32
+	if pressed {
+		value = 1
+	}
+	return d.emit(inputEvent{Type: evKey, Code: code, Value: value})
+}
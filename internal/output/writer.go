@@ -1,63 +1,127 @@
 package output
 
 import (
+	"sync"
+
 	"github.com/bendahl/uinput"
 	"github.com/caedis/noreza/internal/mapping"
 )
 
 type Writer struct {
+	// guards the devices, macros write to them from their own goroutines
+	mu       sync.Mutex
 	keyboard uinput.Keyboard
-	mouse    uinput.Mouse
+	mouse    *mouseDevice
+	// nil unless the virtual gamepad is enabled
+	gamepad *gamepadDevice
+	macros  *macroPlayer
 }
 
-func NewWriter(serial string) (*Writer, error) {
+func NewWriter(serial string, withGamepad bool) (*Writer, error) {
 	kb, err := uinput.CreateKeyboard("/dev/uinput", []byte("noreza-keyboard-"+serial[len(serial)-4:]))
 	if err != nil {
 		return nil, err
 	}
-	mouse, err := uinput.CreateMouse("/dev/uinput", []byte("noreza-mouse-"+serial[len(serial)-4:]))
+	mouse, err := createMouse("/dev/uinput", "noreza-mouse-"+serial[len(serial)-4:])
 	if err != nil {
 		return nil, err
 	}
-	return &Writer{keyboard: kb, mouse: mouse}, nil
+	w := &Writer{keyboard: kb, mouse: mouse}
+	if withGamepad {
+		w.gamepad, err = createGamepad("/dev/uinput", "noreza-gamepad-"+serial[len(serial)-4:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.macros = newMacroPlayer(w)
+	return w, nil
 }
 
 func (w *Writer) Close() {
+	w.macros.stopAll()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	w.keyboard.Close()
 	w.mouse.Close()
+	if w.gamepad != nil {
+		w.gamepad.Close()
+	}
 }
 
 func (w *Writer) Apply(press, release []mapping.KeyMapping) {
 	for _, key := range release {
-		switch key.Mode {
-		case mapping.Mouse:
-			switch key.Code {
-			case 0x110:
-				w.mouse.LeftRelease()
-			case 0x112:
-				w.mouse.MiddleRelease()
-			case 0x111:
-				w.mouse.RightRelease()
-			case 0x008:
-				// TODO Wheel support
-			}
-		case mapping.Keyboard:
-			w.keyboard.KeyUp(key.Code)
+		if key.Mode == mapping.MacroPlayback {
+			w.macros.release(key.Macro)
+			continue
 		}
+		w.keyUp(key)
 	}
 	for _, key := range press {
-		switch key.Mode {
-		case mapping.Mouse:
-			switch key.Code {
-			case 0x110:
-				w.mouse.LeftPress()
-			case 0x112:
-				w.mouse.MiddlePress()
-			case 0x111:
-				w.mouse.RightPress()
+		if key.Mode == mapping.MacroPlayback {
+			w.macros.press(key.Macro)
+			continue
+		}
+		w.keyDown(key)
+	}
+}
+
+func (w *Writer) keyDown(key mapping.KeyMapping) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch key.Mode {
+	case mapping.Mouse:
+		if key.IsWheel() {
+			// one notch per press, held wheel bindings are repeated upstream
+			code, delta := key.Code, int32(1)
+			if code < 0 {
+				code, delta = -code, -1
 			}
-		case mapping.Keyboard:
-			w.keyboard.KeyDown(key.Code)
+			w.mouse.Wheel(uint16(code), delta)
+			return
+		}
+		w.mouse.Button(uint16(key.Code), true)
+	case mapping.Gamepad:
+		if w.gamepad != nil {
+			w.gamepad.Button(uint16(key.Code), true)
 		}
+	case mapping.Keyboard:
+		w.keyboard.KeyDown(key.Code)
 	}
 }
+
+func (w *Writer) keyUp(key mapping.KeyMapping) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch key.Mode {
+	case mapping.Mouse:
+		if key.IsWheel() {
+			return
+		}
+		w.mouse.Button(uint16(key.Code), false)
+	case mapping.Gamepad:
+		if w.gamepad != nil {
+			w.gamepad.Button(uint16(key.Code), false)
+		}
+	case mapping.Keyboard:
+		w.keyboard.KeyUp(key.Code)
+	}
+}
+
+func (w *Writer) MoveMouse(dx, dy int32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.mouse.Move(dx, dy)
+}
+
+func (w *Writer) MoveStick(x, y int16) {
+	if w.gamepad == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.gamepad.Stick(x, y)
+}
@@ -1,6 +1,7 @@
 package paths
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 )
@@ -20,3 +21,11 @@ func DeviceDir(serial string) string {
 func ProfilesDir(serial string) string {
 	return filepath.Join(DeviceDir(serial), "profiles")
 }
+
+// Where the daemon listens for command line clients
+func SocketPath() string {
+	if xdg := os.Getenv("XDG_RUNTIME_DIR"); xdg != "" {
+		return filepath.Join(xdg, "noreza.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("noreza-%d.sock", os.Getuid()))
+}
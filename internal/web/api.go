@@ -0,0 +1,373 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/caedis/noreza/internal/mapping"
+)
+
+// What the API reports about a device
+type DeviceInfo struct {
+	Identifier    string `json:"identifier"`
+	Model         string `json:"model"`
+	ProductID     uint16 `json:"product_id"`
+	Serial        string `json:"serial,omitempty"`
+	Path          string `json:"path"`
+	Connected     bool   `json:"connected"`
+	Grabbed       bool   `json:"grabbed"`
+	ActiveProfile string `json:"active_profile"`
+	PinnedProfile string `json:"pinned_profile,omitempty"`
+}
+
+type apiProfile struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+	Pinned bool   `json:"pinned"`
+}
+
+type apiProfileName struct {
+	Name string `json:"name"`
+}
+
+func (d *Device) Info() DeviceInfo {
+	store := d.Store
+	model, _ := mapping.GetDeviceFromID(store.ProductID)
+	active, _ := store.ActiveProfile.Load().(string)
+	return DeviceInfo{
+		Identifier:    d.Identifier,
+		Model:         model,
+		ProductID:     store.ProductID,
+		Serial:        d.Reader.Serial(),
+		Path:          d.Reader.Path(),
+		Connected:     store.Connected.Load(),
+		Grabbed:       d.Reader.Grabbed(),
+		ActiveProfile: active,
+		PinnedProfile: store.PinnedProfile(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err string) {
+	writeJSON(w, status, map[string]string{"error": err})
+}
+
+// Only takes JSON bodies, so a form posted from another site can't reach it
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "body must be application/json")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %s", err))
+		return false
+	}
+	return true
+}
+
+func apiProfiles(store *mapping.Store) []apiProfile {
+	profiles := make([]apiProfile, 0)
+	for _, p := range store.ListProfiles() {
+		profiles = append(profiles, apiProfile{Name: p.Name, Active: p.Active, Pinned: p.Pinned})
+	}
+	return profiles
+}
+
+// Reads a new profile name from the body, rejecting ones already taken
+func readProfileName(w http.ResponseWriter, r *http.Request, store *mapping.Store) (string, bool) {
+	var body apiProfileName
+	if !readJSON(w, r, &body) {
+		return "", false
+	}
+	name := mapping.CleanProfileName(body.Name)
+	if name == "" {
+		writeAPIError(w, http.StatusBadRequest, "profile name missing")
+		return "", false
+	}
+	if store.ProfileExists(name) {
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf("profile %s already exists", name))
+		return "", false
+	}
+	return name, true
+}
+
+// Looks up the profile named in the path, reporting a 404 if it's missing
+func apiProfileMapping(w http.ResponseWriter, r *http.Request, store *mapping.Store) (string, *mapping.Mapping, bool) {
+	name := r.PathValue("profile")
+	m, ok := (*store.RawMappings.Load())[name]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("profile %s not found", name))
+		return "", nil, false
+	}
+	return name, m, true
+}
+
+func validBindingTarget(keyType, subKey string) bool {
+	switch keyType {
+	case "button":
+		return subKey == ""
+	case "axis":
+		return subKey == "positive" || subKey == "negative"
+	case "hat":
+		return subKey == "up" || subKey == "down" || subKey == "left" || subKey == "right"
+	case "sector":
+		return subKey == "" || subKey == "ring"
+	case "chord":
+		return subKey == ""
+	}
+	return false
+}
+
+// Versioned JSON API for scripts. Devices are picked the same way as the web
+// interface, with the X-Noreza-Device header or ?device=. Browsers can only
+// use it from the web interface's own origin.
+func registerAPI(handleAny func(string, func(http.ResponseWriter, *http.Request, *Device)), devices []Device) {
+	handle := func(pattern string, handler func(http.ResponseWriter, *http.Request, *Device)) {
+		handleAny(pattern, func(w http.ResponseWriter, r *http.Request, dev *Device) {
+			if !sameOrigin(r) {
+				writeAPIError(w, http.StatusForbidden, "cross origin request refused")
+				return
+			}
+			handler(w, r, dev)
+		})
+	}
+
+	handle("GET /api/v1/devices", func(w http.ResponseWriter, r *http.Request, _ *Device) {
+		infos := make([]DeviceInfo, 0, len(devices))
+		for i := range devices {
+			infos = append(infos, devices[i].Info())
+		}
+		writeJSON(w, http.StatusOK, infos)
+	})
+
+	handle("GET /api/v1/device", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		writeJSON(w, http.StatusOK, dev.Info())
+	})
+
+	handle("GET /api/v1/metadata", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		writeJSON(w, http.StatusOK, dev.Store.Metadata.Load())
+	})
+
+	handle("PUT /api/v1/metadata", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		var metadata mapping.Metadata
+		if !readJSON(w, r, &metadata) {
+			return
+		}
+		if metadata.FallbackProfile != "" && !store.ProfileExists(metadata.FallbackProfile) {
+			writeAPIError(w, http.StatusUnprocessableEntity, fmt.Sprintf("profile %s not found", metadata.FallbackProfile))
+			return
+		}
+		// pinning goes through the pin endpoints so the profile is activated too
+		metadata.PinnedProfile = store.PinnedProfile()
+
+		store.Metadata.Store(&metadata)
+		if err := store.SaveMetadata(); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if metadata.ExclusiveAccess {
+			dev.Reader.Grab()
+		} else {
+			dev.Reader.Ungrab()
+		}
+		writeJSON(w, http.StatusOK, metadata)
+	})
+
+	handle("GET /api/v1/profiles", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		writeJSON(w, http.StatusOK, apiProfiles(dev.Store))
+	})
+
+	handle("POST /api/v1/profiles", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		name, ok := readProfileName(w, r, store)
+		if !ok {
+			return
+		}
+		if err := store.CreateNewProfile(name + ".json"); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, apiProfileName{Name: name})
+	})
+
+	handle("PATCH /api/v1/profiles/{profile}", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		profile, _, ok := apiProfileMapping(w, r, store)
+		if !ok {
+			return
+		}
+		name, ok := readProfileName(w, r, store)
+		if !ok {
+			return
+		}
+		if err := store.RenameProfile(profile, name); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, apiProfileName{Name: name})
+	})
+
+	handle("POST /api/v1/profiles/{profile}/duplicate", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		profile, _, ok := apiProfileMapping(w, r, store)
+		if !ok {
+			return
+		}
+		name, ok := readProfileName(w, r, store)
+		if !ok {
+			return
+		}
+		if err := store.DuplicateProfile(profile, name); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, apiProfileName{Name: name})
+	})
+
+	handle("DELETE /api/v1/profiles/{profile}", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		profile, _, ok := apiProfileMapping(w, r, store)
+		if !ok {
+			return
+		}
+		if err := store.DeleteProfile(profile); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	handle("POST /api/v1/profiles/{profile}/activate", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		profile, _, ok := apiProfileMapping(w, r, store)
+		if !ok {
+			return
+		}
+		if err := store.ActivateProfile(profile); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, apiProfiles(store))
+	})
+
+	handle("POST /api/v1/profiles/{profile}/pin", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		profile, _, ok := apiProfileMapping(w, r, store)
+		if !ok {
+			return
+		}
+		if err := store.PinProfile(profile); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, apiProfiles(store))
+	})
+
+	handle("DELETE /api/v1/pin", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		if err := store.UnpinProfile(); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, apiProfiles(store))
+	})
+
+	handle("GET /api/v1/profiles/{profile}/mapping", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		_, m, ok := apiProfileMapping(w, r, dev.Store)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	})
+
+	handle("PUT /api/v1/profiles/{profile}/mapping", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		profile, _, ok := apiProfileMapping(w, r, store)
+		if !ok {
+			return
+		}
+		var m mapping.Mapping
+		if !readJSON(w, r, &m) {
+			return
+		}
+		for i, rule := range m.Rules() {
+			if err := rule.Validate(); err != nil {
+				writeAPIError(w, http.StatusUnprocessableEntity, fmt.Sprintf("rule %d: %s", i+1, err))
+				return
+			}
+		}
+		if err := store.WriteMapping(profile, m); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	})
+
+	handle("GET /api/v1/export", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		bundle, err := dev.Store.ExportBundle(r.URL.Query()["profile"]...)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, bundle)
+	})
+
+	// conflict is rename (the default), overwrite or skip
+	handle("POST /api/v1/import", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		var bundle mapping.Bundle
+		if !readJSON(w, r, &bundle) {
+			return
+		}
+		imported, err := dev.Store.ImportBundle(bundle, mapping.ImportConflict(r.URL.Query().Get("conflict")))
+		if err != nil {
+			writeJSON(w, importStatus(imported), map[string]any{"error": err.Error(), "imported": imported})
+			return
+		}
+		writeJSON(w, http.StatusOK, imported)
+	})
+
+	// subkey is the axis direction (positive/negative), hat direction or ring
+	// for the outer ring of sectors mode. Chords are added through the mapping
+	// and bound by their position in it.
+	patchBinding := func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		profile, m, ok := apiProfileMapping(w, r, store)
+		if !ok {
+			return
+		}
+		keyType := r.PathValue("type")
+		subKey := r.PathValue("subkey")
+		index, err := strconv.ParseUint(r.PathValue("index"), 10, 8)
+		if err != nil || !validBindingTarget(keyType, subKey) || (keyType == "chord" && int(index) >= len(m.Chords)) {
+			writeAPIError(w, http.StatusNotFound, "no such input")
+			return
+		}
+		var binding mapping.Binding
+		if !readJSON(w, r, &binding) {
+			return
+		}
+		if binding.Keys == nil {
+			binding.Keys = []mapping.KeyMapping{}
+		}
+
+		// the stored mapping is only replaced once the change is saved
+		updated := m.Clone()
+		updated.UpdateBinding(keyType, subKey, uint8(index), binding)
+		if err := store.WriteMapping(profile, *updated); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, binding)
+	}
+	handle("PATCH /api/v1/profiles/{profile}/bindings/{type}/{index}", patchBinding)
+	handle("PATCH /api/v1/profiles/{profile}/bindings/{type}/{index}/{subkey}", patchBinding)
+}
@@ -0,0 +1,33 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadJSONContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"application/x-www-form-urlencoded", false},
+		{"text/plain", false},
+		{"multipart/form-data; boundary=x", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/profiles", strings.NewReader(`{"name": "new"}`))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		var body apiProfileName
+		if got := readJSON(w, r, &body); got != tt.want {
+			t.Errorf("readJSON with %q = %t, want %t (status %d)", tt.contentType, got, tt.want, w.Code)
+		}
+	}
+}
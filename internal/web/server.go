@@ -6,9 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +21,7 @@ import (
 	"github.com/a-h/templ"
 	"github.com/caedis/noreza/internal/input"
 	"github.com/caedis/noreza/internal/mapping"
+	"github.com/caedis/noreza/internal/shared/paths"
 	"github.com/caedis/noreza/internal/web/templates"
 )
 
@@ -24,26 +30,256 @@ type rawMapping struct {
 	Mode mapping.KeyMode `json:"mode"`
 }
 
+type rawHold struct {
+	Enabled    bool         `json:"enabled"`
+	Keys       []rawMapping `json:"keys"`
+	TimeoutMs  int          `json:"timeout_ms"`
+	Permissive bool         `json:"permissive"`
+}
+
+type rawTurbo struct {
+	Enabled  bool    `json:"enabled"`
+	Rate     float64 `json:"rate"`
+	JitterMs int     `json:"jitter_ms"`
+}
+
+type rawMacroStep struct {
+	Action  mapping.MacroAction `json:"action"`
+	Key     *rawMapping         `json:"key,omitempty"`
+	DelayMs int                 `json:"delay_ms,omitempty"`
+}
+
+type rawMacro struct {
+	Enabled bool              `json:"enabled"`
+	Mode    mapping.MacroMode `json:"mode"`
+	Steps   []rawMacroStep    `json:"steps"`
+}
+
+// Converts a key to the name the browser uses for it
+func toRawKey(key mapping.KeyMapping) (rawMapping, bool) {
+	switch key.Mode {
+	case mapping.Mouse:
+		return rawMapping{Mode: key.Mode, Code: mapping.CodeToMouse[key.Code]}, true
+	case mapping.Gamepad:
+		return rawMapping{Mode: key.Mode, Code: mapping.CodeToGamepad[key.Code]}, true
+	case mapping.Keyboard:
+		return rawMapping{Mode: key.Mode, Code: mapping.KeyName(key.Code)}, true
+	}
+	return rawMapping{}, false
+}
+
+func fromRawKey(raw rawMapping) (mapping.KeyMapping, bool) {
+	switch raw.Mode {
+	case mapping.Mouse:
+		return mapping.KeyMapping{Mode: raw.Mode, Code: mapping.MouseToCode[raw.Code]}, true
+	case mapping.Gamepad:
+		return mapping.KeyMapping{Mode: raw.Mode, Code: mapping.GamepadToCode[raw.Code]}, true
+	case mapping.Keyboard:
+		code, ok := mapping.ParseKey(raw.Code)
+		return mapping.KeyMapping{Mode: raw.Mode, Code: code}, ok
+	}
+	return mapping.KeyMapping{}, false
+}
+
+func toRawKeys(keys []mapping.KeyMapping) []rawMapping {
+	clientKeys := make([]rawMapping, 0)
+	for _, key := range keys {
+		if key.Code == 0 {
+			continue
+		}
+		if raw, ok := toRawKey(key); ok {
+			clientKeys = append(clientKeys, raw)
+		}
+	}
+	return clientKeys
+}
+
+func fromRawKeys(rawKeys []rawMapping) []mapping.KeyMapping {
+	keys := make([]mapping.KeyMapping, 0)
+	for _, v := range rawKeys {
+		if key, ok := fromRawKey(v); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func toRawMacro(macro *mapping.Macro) rawMacro {
+	raw := rawMacro{Steps: []rawMacroStep{}}
+	if macro == nil {
+		return raw
+	}
+
+	raw.Enabled = true
+	raw.Mode = macro.Mode
+	for _, step := range macro.Steps {
+		rawStep := rawMacroStep{Action: step.Action, DelayMs: step.DelayMs}
+		if step.Action != mapping.MacroWait {
+			if key, ok := toRawKey(step.Key); ok {
+				rawStep.Key = &key
+			}
+		}
+		raw.Steps = append(raw.Steps, rawStep)
+	}
+	return raw
+}
+
+func fromRawMacro(raw rawMacro) *mapping.Macro {
+	if !raw.Enabled {
+		return nil
+	}
+
+	macro := &mapping.Macro{Mode: raw.Mode, Steps: []mapping.MacroStep{}}
+	for _, rawStep := range raw.Steps {
+		step := mapping.MacroStep{Action: rawStep.Action, DelayMs: rawStep.DelayMs}
+		if step.Action != mapping.MacroWait {
+			if rawStep.Key == nil {
+				continue
+			}
+			key, ok := fromRawKey(*rawStep.Key)
+			if !ok {
+				continue
+			}
+			step.Key = key
+		}
+		macro.Steps = append(macro.Steps, step)
+	}
+	return macro
+}
+
+// Reads a chord's buttons as the editor numbers them, like "5+6"
+func parseChordButtons(raw string) ([]uint8, error) {
+	var buttons []uint8
+	for field := range strings.FieldsFuncSeq(raw, func(r rune) bool {
+		return r == '+' || r == ',' || r == ' '
+	}) {
+		n, err := strconv.ParseUint(strings.TrimPrefix(field, "#"), 10, 8)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid button %q", field)
+		}
+		if !slices.Contains(buttons, uint8(n-1)) {
+			buttons = append(buttons, uint8(n-1))
+		}
+	}
+	if len(buttons) < 2 {
+		return nil, fmt.Errorf("a chord needs at least two buttons")
+	}
+	slices.Sort(buttons)
+	return buttons, nil
+}
+
+// Describes a failed import, including any profiles it had already saved
+func importFailure(imported []mapping.ImportedProfile, err error) string {
+	var saved []string
+	for _, p := range imported {
+		if p.To != "" {
+			saved = append(saved, p.To)
+		}
+	}
+	if len(saved) == 0 {
+		return err.Error()
+	}
+	return fmt.Sprintf("%s (already imported: %s)", err, strings.Join(saved, ", "))
+}
+
+// A bundle that's rejected before anything is written is the client's fault,
+// a write failing part way through isn't
+func importStatus(imported []mapping.ImportedProfile) int {
+	if len(imported) == 0 {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
 //go:embed static
 var staticFiles embed.FS
 
-func RunServer(ctx context.Context, port int, store *mapping.Store, reader *input.Reader, serial string) {
+// A device managed by this daemon
+type Device struct {
+	Identifier string
+	Store      *mapping.Store
+	Reader     *input.Reader
+}
+
+// htmx sends this on every request, set from the device picked on page load
+const deviceHeader = "X-Noreza-Device"
+
+// Finds which device a request is for, defaulting to the first one
+func lookupDevice(devices []Device, r *http.Request) (*Device, bool) {
+	id := r.Header.Get(deviceHeader)
+	if id == "" {
+		id = r.URL.Query().Get("device")
+	}
+	if id == "" {
+		return &devices[0], true
+	}
+	for i := range devices {
+		if devices[i].Identifier == id {
+			return &devices[i], true
+		}
+	}
+	return nil, false
+}
+
+// Whether a request came from the web interface itself, or from something
+// that isn't a browser, rather than another site open in the browser or a
+// hostname rebound to the loopback address. The command socket is only
+// reachable by the user, so it's always trusted.
+func sameOrigin(r *http.Request) bool {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && addr.Network() == "unix" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	switch host {
+	case "localhost", "127.0.0.1", "::1":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func RunServer(ctx context.Context, port int, devices []Device) {
 	mux := http.NewServeMux()
 	mux.Handle("/static/", http.FileServerFS(staticFiles))
 
-	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
-		templates.Layout(reader.String(), serial).Render(r.Context(), w)
+	handle := func(pattern string, handler func(w http.ResponseWriter, r *http.Request, dev *Device)) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			dev, ok := lookupDevice(devices, r)
+			if !ok {
+				http.Error(w, "device not found", http.StatusNotFound)
+				return
+			}
+			handler(w, r, dev)
+		})
+	}
+
+	handle("GET /{$}", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		options := make([]templates.DeviceOption, 0, len(devices))
+		for _, d := range devices {
+			options = append(options, templates.DeviceOption{
+				Identifier: d.Identifier,
+				Name:       d.Reader.String(),
+			})
+		}
+		templates.Layout(dev.Reader.String(), dev.Identifier, options).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("GET /profiles", func(w http.ResponseWriter, r *http.Request) {
+	handle("GET /profiles", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		templates.ProfileList(store.ListProfiles()).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("POST /profiles", func(w http.ResponseWriter, r *http.Request) {
-		profileName := r.Header.Get("HX-Prompt")
-		profileName = strings.ReplaceAll(profileName, ".", "")
-		profileName = strings.ReplaceAll(profileName, ".json", "")
-		profileName = strings.TrimSpace(profileName)
+	handle("POST /profiles", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		profileName := mapping.CleanProfileName(r.Header.Get("HX-Prompt"))
 		if profileName == "" {
 			http.Error(w, "profile name missing", http.StatusBadRequest)
 			return
@@ -58,7 +294,54 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 		templates.ProfileList(store.ListProfiles()).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("DELETE /profiles/{profile}", func(w http.ResponseWriter, r *http.Request) {
+	handle("GET /profiles/share", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		templates.ShareModal(dev.Store.ListProfiles()).Render(r.Context(), w)
+	})
+
+	handle("GET /profiles/export", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		names := r.URL.Query()["profile"]
+		bundle, err := dev.Store.ExportBundle(names...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		fileName := fmt.Sprintf("noreza-%s-profiles.json", bundle.Model)
+		if len(names) == 1 {
+			fileName = names[0] + ".noreza.json"
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+		writeJSON(w, http.StatusOK, bundle)
+	})
+
+	handle("POST /profiles/import", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		if !sameOrigin(r) {
+			http.Error(w, "cross origin request refused", http.StatusForbidden)
+			return
+		}
+		file, _, err := r.FormFile("bundle")
+		if err != nil {
+			http.Error(w, "missing bundle file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		var bundle mapping.Bundle
+		if err := json.NewDecoder(file).Decode(&bundle); err != nil {
+			http.Error(w, "not a profile bundle", http.StatusBadRequest)
+			return
+		}
+		imported, err := store.ImportBundle(bundle, mapping.ImportConflict(r.FormValue("conflict")))
+		if err != nil {
+			http.Error(w, importFailure(imported, err), importStatus(imported))
+			return
+		}
+
+		templates.ImportResult(imported, store.ListProfiles()).Render(r.Context(), w)
+	})
+
+	handle("DELETE /profiles/{profile}", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		profileName := r.PathValue("profile")
 
 		// delete should trigger store.RemoveProfile
@@ -72,14 +355,15 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 		templates.ProfileList(store.ListProfiles()).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("POST /profiles/{profile}/activate", func(w http.ResponseWriter, r *http.Request) {
+	handle("POST /profiles/{profile}/activate", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		profile := r.PathValue("profile")
 		if profile == "" {
 			http.Error(w, "missing name", http.StatusBadRequest)
 			return
 		}
 
-		if err := store.SetActiveProfile(profile); err != nil {
+		if err := store.ActivateProfile(profile); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -87,7 +371,28 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 		templates.ProfileList(store.ListProfiles()).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("GET /profiles/{profile}/update", func(w http.ResponseWriter, r *http.Request) {
+	handle("POST /profiles/{profile}/pin", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		if err := store.PinProfile(r.PathValue("profile")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		templates.ProfileList(store.ListProfiles()).Render(r.Context(), w)
+	})
+
+	handle("DELETE /profiles/{profile}/pin", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		if err := store.UnpinProfile(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		templates.ProfileList(store.ListProfiles()).Render(r.Context(), w)
+	})
+
+	handle("GET /profiles/{profile}/update", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		profile := r.PathValue("profile")
 		vals := r.URL.Query()
 
@@ -103,32 +408,38 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 			return
 		}
 
-		clientKeys := make([]rawMapping, 0)
-		existingKeys := keyMap.GetKeys(keyType, subKey, index)
-		for _, key := range existingKeys {
-			if key.Code == 0 {
-				continue
+		binding := keyMap.GetBinding(keyType, subKey, index)
+		hold := rawHold{
+			Keys:      []rawMapping{},
+			TimeoutMs: int(mapping.DefaultHoldTimeout.Milliseconds()),
+		}
+		if binding.Hold != nil {
+			hold.Enabled = true
+			hold.Keys = toRawKeys(binding.Hold.Keys)
+			hold.Permissive = binding.Hold.Permissive
+			if binding.Hold.TimeoutMs > 0 {
+				hold.TimeoutMs = binding.Hold.TimeoutMs
 			}
+		}
 
-			switch key.Mode {
-			case mapping.Mouse:
-				clientKeys = append(clientKeys, rawMapping{
-					Mode: key.Mode,
-					Code: mapping.CodeToMouse[key.Code],
-				})
-			case mapping.Keyboard:
-				clientKeys = append(clientKeys, rawMapping{
-					Mode: key.Mode,
-					Code: mapping.CodeToKey[key.Code],
-				})
+		turbo := rawTurbo{Rate: mapping.DefaultTurboRate}
+		if binding.Turbo != nil {
+			turbo.Enabled = true
+			turbo.JitterMs = binding.Turbo.JitterMs
+			if binding.Turbo.Rate > 0 {
+				turbo.Rate = binding.Turbo.Rate
 			}
 		}
 
-		keyString, _ := templ.JSONString(clientKeys)
-		templates.EditorModal(profile, index, keyType, subKey, keyString).Render(r.Context(), w)
+		keyString, _ := templ.JSONString(toRawKeys(binding.Keys))
+		holdString, _ := templ.JSONString(hold)
+		macroString, _ := templ.JSONString(toRawMacro(binding.Macro))
+		turboString, _ := templ.JSONString(turbo)
+		templates.EditorModal(profile, index, keyType, subKey, keyString, holdString, macroString, turboString, binding.Latch).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("PATCH /profiles/{profile}/update", func(w http.ResponseWriter, r *http.Request) {
+	handle("PATCH /profiles/{profile}/update", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		profile := r.PathValue("profile")
 
 		if err := r.ParseForm(); err != nil {
@@ -143,19 +454,42 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 			return
 		}
 
-		updateKeys := make([]mapping.KeyMapping, 0)
-		for _, v := range *rawMap {
-			switch v.Mode {
-			case mapping.Mouse:
-				updateKeys = append(updateKeys, mapping.KeyMapping{
-					Mode: v.Mode,
-					Code: mapping.MouseToCode[v.Code],
-				})
-			case mapping.Keyboard:
-				updateKeys = append(updateKeys, mapping.KeyMapping{
-					Mode: v.Mode,
-					Code: mapping.KeyToCode[v.Code],
-				})
+		binding := mapping.Binding{Keys: fromRawKeys(*rawMap)}
+
+		if holdRaw := r.PostFormValue("hold"); holdRaw != "" {
+			var hold rawHold
+			if err := json.Unmarshal([]byte(holdRaw), &hold); err != nil {
+				http.Error(w, "unable to parse hold keys", http.StatusBadRequest)
+				return
+			}
+			if hold.Enabled {
+				binding.Hold = &mapping.HoldMapping{
+					Keys:       fromRawKeys(hold.Keys),
+					TimeoutMs:  hold.TimeoutMs,
+					Permissive: hold.Permissive,
+				}
+			}
+		}
+
+		if macroRaw := r.PostFormValue("macro"); macroRaw != "" {
+			var macro rawMacro
+			if err := json.Unmarshal([]byte(macroRaw), &macro); err != nil {
+				http.Error(w, "unable to parse macro", http.StatusBadRequest)
+				return
+			}
+			binding.Macro = fromRawMacro(macro)
+		}
+
+		binding.Latch = mapping.LatchMode(r.PostFormValue("latch"))
+
+		if turboRaw := r.PostFormValue("turbo"); turboRaw != "" {
+			var turbo rawTurbo
+			if err := json.Unmarshal([]byte(turboRaw), &turbo); err != nil {
+				http.Error(w, "unable to parse turbo", http.StatusBadRequest)
+				return
+			}
+			if turbo.Enabled {
+				binding.Turbo = &mapping.TurboMapping{Rate: turbo.Rate, JitterMs: turbo.JitterMs}
 			}
 		}
 
@@ -175,7 +509,7 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 			return
 		}
 
-		m.UpdateBinding(keyType, subKey, uint8(index), updateKeys)
+		m.UpdateBinding(keyType, subKey, uint8(index), binding)
 
 		// Recompile FlatMapping
 		flat := mapping.CompileFlatMapping(*m)
@@ -205,7 +539,8 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 		templates.Editor(*m, profile, device, metadata).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("GET /profiles/{profile}/editor", func(w http.ResponseWriter, r *http.Request) {
+	handle("GET /profiles/{profile}/editor", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		profile := r.PathValue("profile")
 		mappings := *store.RawMappings.Load()
 		m := mappings[profile]
@@ -224,7 +559,8 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 		templates.Editor(*m, profile, device, metadata).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("PATCH /profiles/{profile}/clear", func(w http.ResponseWriter, r *http.Request) {
+	handle("PATCH /profiles/{profile}/clear", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		profile := r.PathValue("profile")
 
 		mappings := store.RawMappings.Load()
@@ -264,16 +600,88 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 		templates.Editor(*m, profile, device, metadata).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("GET /profiles/{profile}/settings", func(w http.ResponseWriter, r *http.Request) {
+	handle("POST /profiles/{profile}/chords", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		profile := r.PathValue("profile")
+
+		m, ok := (*store.RawMappings.Load())[profile]
+		if !ok {
+			http.Error(w, "profile not found", http.StatusNotFound)
+			return
+		}
+		buttons, err := parseChordButtons(r.FormValue("buttons"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		for _, chord := range m.Chords {
+			if slices.Equal(slices.Sorted(slices.Values(chord.Buttons)), buttons) {
+				http.Error(w, "that chord already exists", http.StatusConflict)
+				return
+			}
+		}
+
+		m = m.Clone()
+		m.Chords = append(m.Chords, mapping.Chord{Buttons: buttons, Binding: mapping.Binding{Keys: []mapping.KeyMapping{}}})
+		if err := store.WriteMapping(profile, *m); err != nil {
+			http.Error(w, "error saving profile", http.StatusInternalServerError)
+			return
+		}
+
+		device, err := mapping.GetDeviceFromID(store.ProductID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		templates.Editor(*m, profile, device, *store.Metadata.Load()).Render(r.Context(), w)
+	})
+
+	handle("DELETE /profiles/{profile}/chords/{index}", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		profile := r.PathValue("profile")
+
+		m, ok := (*store.RawMappings.Load())[profile]
+		if !ok {
+			http.Error(w, "profile not found", http.StatusNotFound)
+			return
+		}
+		index, err := strconv.Atoi(r.PathValue("index"))
+		if err != nil || index < 0 || index >= len(m.Chords) {
+			http.Error(w, "chord not found", http.StatusNotFound)
+			return
+		}
+
+		m = m.Clone()
+		m.Chords = slices.Delete(m.Chords, index, index+1)
+		if err := store.WriteMapping(profile, *m); err != nil {
+			http.Error(w, "error saving profile", http.StatusInternalServerError)
+			return
+		}
+
+		device, err := mapping.GetDeviceFromID(store.ProductID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		templates.Editor(*m, profile, device, *store.Metadata.Load()).Render(r.Context(), w)
+	})
+
+	handle("GET /profiles/{profile}/settings", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		profile := r.PathValue("profile")
 
 		mappings := *store.RawMappings.Load()
-		windowProfile := mappings[profile].WindowProfile
+		m, ok := mappings[profile]
+		if !ok {
+			http.Error(w, "profile not found", http.StatusNotFound)
+			return
+		}
 
-		templates.SettingsModal(profile, mappings[profile].AxisDeadzone, windowProfile).Render(r.Context(), w)
+		templates.SettingsModal(profile, *m).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("PATCH /profiles/{profile}/settings/update", func(w http.ResponseWriter, r *http.Request) {
+	handle("PATCH /profiles/{profile}/settings/update", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		profile := r.PathValue("profile")
 
 		if err := r.ParseForm(); err != nil {
@@ -281,8 +689,6 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 			return
 		}
 
-		namePattern := r.FormValue("nameRegex")
-		classPattern := r.FormValue("classRegex")
 		deadzoneRaw := r.FormValue("deadzone")
 		deadzone, _ := strconv.Atoi(deadzoneRaw)
 
@@ -293,10 +699,60 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 			return
 		}
 
-		m.WindowProfile.NamePattern = namePattern
-		m.WindowProfile.ClassPattern = classPattern
+		var rules []mapping.WindowRule
+		if err := json.Unmarshal([]byte(r.FormValue("windowRules")), &rules); err != nil {
+			http.Error(w, "unable to parse window rules", http.StatusBadRequest)
+			return
+		}
+		for i, rule := range rules {
+			if err := rule.Validate(); err != nil {
+				http.Error(w, fmt.Sprintf("Rule %d: %s", i+1, err), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		// rules without any pattern would never match
+		rules = slices.DeleteFunc(rules, func(rule mapping.WindowRule) bool {
+			return rule.WindowProfileCfg == (mapping.WindowProfileCfg{})
+		})
+		// the old single rule is folded into the list once it's been edited
+		m.WindowProfile = mapping.WindowProfileCfg{}
+		m.WindowRules = rules
 
 		m.AxisDeadzone = int16(deadzone)
+		m.DeadzoneShape = mapping.DeadzoneShape(r.FormValue("deadzoneShape"))
+		// other axes can only be set in the profile JSON
+		deadzones := maps.Clone(m.AxisDeadzones)
+		for _, axis := range []struct {
+			name  string
+			index uint8
+		}{{"x", mapping.StickXAxis}, {"y", mapping.StickYAxis}} {
+			inner, _ := strconv.Atoi(r.FormValue("deadzoneInner" + axis.name))
+			outer, _ := strconv.Atoi(r.FormValue("deadzoneOuter" + axis.name))
+			if inner == 0 && outer == 0 {
+				delete(deadzones, axis.index)
+				continue
+			}
+			if deadzones == nil {
+				deadzones = make(map[uint8]mapping.AxisDeadzoneCfg)
+			}
+			deadzones[axis.index] = mapping.AxisDeadzoneCfg{Inner: int16(inner), Outer: int16(outer)}
+		}
+		m.AxisDeadzones = deadzones
+		hysteresis, _ := strconv.Atoi(r.FormValue("deadzoneHysteresis"))
+		m.DeadzoneHysteresis = int16(hysteresis)
+
+		m.WheelRepeatMs, _ = strconv.Atoi(r.FormValue("wheelRepeat"))
+		m.ChordWindowMs, _ = strconv.Atoi(r.FormValue("chordWindow"))
+
+		m.JoystickMode = mapping.JoystickMode(r.FormValue("joystickMode"))
+		m.SectorCount, _ = strconv.Atoi(r.FormValue("sectorCount"))
+		ringThreshold, _ := strconv.Atoi(r.FormValue("ringThreshold"))
+		m.RingThreshold = int16(ringThreshold)
+		m.Mouse.Sensitivity, _ = strconv.ParseFloat(r.FormValue("mouseSensitivity"), 64)
+		m.Mouse.Acceleration, _ = strconv.ParseFloat(r.FormValue("mouseAcceleration"), 64)
+		mouseDeadzone, _ := strconv.Atoi(r.FormValue("mouseDeadzone"))
+		m.Mouse.Deadzone = int16(mouseDeadzone)
+		m.GamepadCurve, _ = strconv.ParseFloat(r.FormValue("gamepadCurve"), 64)
 
 		path := filepath.Join(store.ProfilePath, profile+".json")
 		if err := m.WriteToFile(path); err != nil {
@@ -304,10 +760,17 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 			return
 		}
 
-		w.WriteHeader(http.StatusNoContent)
+		// the joystick mode can change how the editor lays out the stick
+		device, err := mapping.GetDeviceFromID(store.ProductID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		templates.Editor(*m, profile, device, *store.Metadata.Load()).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+	handle("/events", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
@@ -325,6 +788,12 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 
 		fmt.Fprintf(w, "event: activeProfile\n")
 		fmt.Fprintf(w, "data: \"%s\"\n\n", store.ActiveProfile.Load())
+		fmt.Fprintf(w, "event: %s\n", mapping.EventDevice)
+		status, _ := json.Marshal(mapping.DeviceStatus{Connected: store.Connected.Load()})
+		fmt.Fprintf(w, "data: %s\n\n", status)
+		fmt.Fprintf(w, "event: %s\n", mapping.EventLatched)
+		latched, _ := json.Marshal(store.LatchedControls())
+		fmt.Fprintf(w, "data: %s\n\n", latched)
 		flusher.Flush()
 
 		for {
@@ -343,13 +812,42 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 		}
 	})
 
-	mux.HandleFunc("GET /device/settings", func(w http.ResponseWriter, r *http.Request) {
+	handle("GET /rules", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		templates.RulesModal(*store.WindowProfiles.Load(), store.InvalidRules(), store.FocusedWindow.Load(), *store.Metadata.Load()).Render(r.Context(), w)
+	})
+
+	handle("POST /rules/test", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rule := mapping.WindowRule{
+			WindowProfileCfg: mapping.WindowProfileCfg{
+				NamePattern:     r.FormValue("name"),
+				ClassPattern:    r.FormValue("class"),
+				ProcessPattern:  r.FormValue("process"),
+				SteamAppPattern: r.FormValue("steam_app"),
+			},
+			MatchAll: r.FormValue("match_all") == "true",
+		}
+		wp, err := mapping.CompileWindowRule("", 0, rule)
+		win := store.FocusedWindow.Load()
+		matched := err == nil && win != nil && rule.WindowProfileCfg != (mapping.WindowProfileCfg{}) && wp.Matches(*win)
+		templates.RuleTestResult(err, win, matched).Render(r.Context(), w)
+	})
+
+	handle("GET /device/settings", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		metadata := store.Metadata.Load()
 
-		templates.DeviceSettingsModal(*metadata).Render(r.Context(), w)
+		templates.DeviceSettingsModal(*metadata, store.ListProfiles()).Render(r.Context(), w)
 	})
 
-	mux.HandleFunc("PATCH /device/settings", func(w http.ResponseWriter, r *http.Request) {
+	handle("PATCH /device/settings", func(w http.ResponseWriter, r *http.Request, dev *Device) {
+		store := dev.Store
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -361,20 +859,24 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 		oppositeHand := r.FormValue("oppositeHand")
 		exclusiveAccess := r.FormValue("exclusiveAccess")
 		invertAxes := r.FormValue("invertAxes")
+		virtualGamepad := r.FormValue("virtualGamepad")
 
 		metadata := mapping.Metadata{
 			IsOppositeHand:  oppositeHand == "on",
 			ExclusiveAccess: exclusiveAccess == "on",
-			InvertAxes: invertAxes == "on",
+			InvertAxes:      invertAxes == "on",
+			VirtualGamepad:  virtualGamepad == "on",
+			FallbackProfile: r.FormValue("fallbackProfile"),
+			PinnedProfile:   store.PinnedProfile(),
 		}
 
 		store.Metadata.Store(&metadata)
 		err := store.SaveMetadata()
 
 		if metadata.ExclusiveAccess {
-			reader.Grab()
+			dev.Reader.Grab()
 		} else {
-			reader.Ungrab()
+			dev.Reader.Ungrab()
 		}
 
 		if err != nil {
@@ -396,6 +898,8 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 		}
 	})
 
+	registerAPI(handle, devices)
+
 	srv := &http.Server{
 		Addr:    fmt.Sprintf("localhost:%d", port),
 		Handler: mux,
@@ -409,6 +913,38 @@ func RunServer(ctx context.Context, port int, store *mapping.Store, reader *inpu
 		}
 	}()
 
+	go serveSocket(ctx, mux)
+
 	<-ctx.Done()
 	log.Println("Closing web server")
 }
+
+// Serves the same routes on a Unix socket for the command line client
+func serveSocket(ctx context.Context, handler http.Handler) {
+	socketPath := paths.SocketPath()
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		log.Printf("Another noreza is already listening on %s, command line client disabled", socketPath)
+		return
+	}
+	// left behind by a daemon that didn't get to clean up
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		log.Printf("Unable to listen on %s: %s", socketPath, err)
+		return
+	}
+	os.Chmod(socketPath, 0600)
+
+	srv := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	log.Printf("Command socket listening on %s", socketPath)
+	if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+		log.Printf("command socket: %s", err)
+	}
+}
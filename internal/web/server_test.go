@@ -0,0 +1,46 @@
+package web
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		socket bool
+		want   bool
+	}{
+		{name: "web interface", host: "localhost:1337", origin: "http://localhost:1337", want: true},
+		{name: "loopback address", host: "127.0.0.1:1337", origin: "http://127.0.0.1:1337", want: true},
+		{name: "ipv6 loopback", host: "[::1]:1337", origin: "http://[::1]:1337", want: true},
+		{name: "no origin", host: "localhost:1337", want: true},
+		{name: "other site", host: "localhost:1337", origin: "https://example.com"},
+		{name: "other port", host: "localhost:1337", origin: "http://localhost:8080"},
+		{name: "opaque origin", host: "localhost:1337", origin: "null"},
+		{name: "rebound hostname", host: "attacker.example:1337", origin: "http://attacker.example:1337"},
+		{name: "rebound hostname without origin", host: "attacker.example:1337"},
+		{name: "command socket", host: "noreza", socket: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/profiles/import", nil)
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.socket {
+				addr := &net.UnixAddr{Name: "/run/user/1000/noreza.sock", Net: "unix"}
+				r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, addr))
+			}
+			if got := sameOrigin(r); got != tt.want {
+				t.Errorf("sameOrigin() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -12,8 +12,13 @@ document.addEventListener('alpine:init', () => {
         selectedProfile: null,
     });
 
+    Alpine.store('device', {
+        connected: true,
+    });
+
     // Create a single SSE connection
-    const sse = new EventSource('/events');
+    // Events are per device, so follow the one this page is showing
+    const sse = new EventSource('/events?device=' + encodeURIComponent(document.body.dataset.device));
 
     sse.onopen = () => {
         console.log('SSE connected');
@@ -42,6 +47,24 @@ document.addEventListener('alpine:init', () => {
         }
     });
 
+    sse.addEventListener('device', ev => {
+        try {
+            const data = JSON.parse(ev.data);
+            Alpine.store('device').connected = data.connected;
+            if (!data.connected) clearPressed();
+        } catch (e) {
+            console.error('Invalid device event', e);
+        }
+    });
+
+    sse.addEventListener('latched', ev => {
+        try {
+            showLatched(JSON.parse(ev.data));
+        } catch (e) {
+            console.error('Invalid latched event', e);
+        }
+    });
+
     sse.addEventListener('joystick', ev => {
         const data = JSON.parse(ev.data);
 
@@ -58,6 +81,9 @@ document.addEventListener('alpine:init', () => {
         }
     })
 
+    // the editor is re-rendered after each change, losing the highlights
+    document.body.addEventListener('htmx:afterSwap', () => showLatched(latchedControls));
+
     // Optional cleanup when page unloads
     window.addEventListener('beforeunload', () => sse.close());
 });
@@ -66,8 +92,138 @@ function updateDeadzone(val) {
     let value = document.getElementById("deadzoneSlider").value
     deadzone = value
     document.getElementById("deadzoneText").innerText = value
+    drawDeadzone()
+}
+
+// Live preview of the deadzone fields in the settings modal
+function updateStick(field, value) {
+    stick[field] = field === 'shape' ? value : Number(value) || 0;
+    drawDeadzone()
+}
+
+// Mirrors the deadzone handling in internal/mapping/deadzone.go
+const SECTOR_ENTER = Math.sin(22.5 * Math.PI / 180);
+const SECTOR_EXIT = Math.sin(17.5 * Math.PI / 180);
+let stickDir = [0, 0];
+
+function stickShape() {
+    return stick.shape || stick.default_shape;
+}
+
+function stickInner() {
+    return [stick.inner_x || Number(deadzone), stick.inner_y || Number(deadzone)];
+}
+
+function stickOuter() {
+    return [stick.outer_x || MAX_VALUE, stick.outer_y || MAX_VALUE];
+}
+
+function drawDeadzone() {
+    const inner = document.getElementById("joystick-deadzone");
+    const outer = document.getElementById("joystick-outer");
+    if (!inner || !outer || typeof stick === 'undefined') return;
+
+    const scale = BOX_SIZE / MAX_VALUE;
+    const radial = stickShape() === 'radial';
+    const draw = (el, [x, y]) => {
+        el.style.width = `${x * scale}px`;
+        el.style.height = `${y * scale}px`;
+        el.classList.toggle("rounded-full", radial);
+    };
+    draw(inner, stickInner());
+    draw(outer, stickOuter());
+    outer.hidden = !stick.outer_x && !stick.outer_y;
+}
+
+function axisDirection(value, inner, prev) {
+    const exit = Math.max(inner - stick.hysteresis, 0);
+    if (value >= inner || (prev > 0 && value > exit)) return 1;
+    if (value <= -inner || (prev < 0 && value < -exit)) return -1;
+    return 0;
 }
 
+function sectorDirection(d, prev) {
+    if (d >= SECTOR_ENTER || (prev > 0 && d >= SECTOR_EXIT)) return 1;
+    if (d <= -SECTOR_ENTER || (prev < 0 && d <= -SECTOR_EXIT)) return -1;
+    return 0;
+}
+
+function stickDirections() {
+    const [innerX, innerY] = stickInner();
+    if (stickShape() !== 'radial') {
+        return [axisDirection(axisX, innerX, stickDir[0]), axisDirection(axisY, innerY, stickDir[1])];
+    }
+
+    const magnitude = Math.hypot(axisX, axisY);
+    if (magnitude === 0) return [0, 0];
+    const dx = axisX / magnitude;
+    const dy = axisY / magnitude;
+
+    // distance to the edge of the (possibly elliptical) deadzone this way
+    const inner = innerX > 0 && innerY > 0 ? 1 / Math.hypot(dx / innerX, dy / innerY) : 0;
+    if (stickDir[0] || stickDir[1]) {
+        if (magnitude <= Math.max(inner - stick.hysteresis, 0)) return [0, 0];
+    } else if (magnitude < inner) {
+        return [0, 0];
+    }
+    return [sectorDirection(dx, stickDir[0]), sectorDirection(dy, stickDir[1])];
+}
+
+// Mirrors stickSector in internal/mapping/sector.go
+const SECTOR_MARGIN = 5 * Math.PI / 180;
+const RING_FRACTION = 0.9;
+let stickSector = { sector: -1, ring: false };
+
+function stickSectors() {
+    const magnitude = Math.hypot(axisX, axisY);
+    if (magnitude === 0) return { sector: -1, ring: false };
+    const dx = axisX / magnitude;
+    const dy = axisY / magnitude;
+    const ellipse = ([rx, ry]) => rx > 0 && ry > 0 ? 1 / Math.hypot(dx / rx, dy / ry) : 0;
+
+    const inner = ellipse(stickInner());
+    const prev = stickSector.sector;
+    if (prev >= 0) {
+        if (magnitude <= Math.max(inner - stick.hysteresis, 0)) return { sector: -1, ring: false };
+    } else if (magnitude < inner) {
+        return { sector: -1, ring: false };
+    }
+
+    const count = stick.sectors;
+    const width = 2 * Math.PI / count;
+    const angle = Math.atan2(dx, -dy);
+    let sector = Math.floor(((angle + width / 2 + 2 * Math.PI) % (2 * Math.PI)) / width) % count;
+    if (prev >= 0 && prev < count && sector !== prev) {
+        let fromCentre = Math.abs(angle - prev * width) % (2 * Math.PI);
+        fromCentre = Math.min(fromCentre, 2 * Math.PI - fromCentre);
+        if (fromCentre <= width / 2 + Math.min(SECTOR_MARGIN, width / 4)) sector = prev;
+    }
+
+    const ringAt = stick.ring_at || RING_FRACTION * ellipse(stickOuter());
+    const ring = magnitude >= ringAt || (stickSector.ring && magnitude > ringAt - stick.hysteresis);
+    return { sector, ring };
+}
+
+
+// Inputs whose keys stay down after they were let go, e.g. toggles
+let latchedControls = [];
+
+function showLatched(controls) {
+    latchedControls = controls;
+    document.querySelectorAll('[data-key]').forEach(el =>
+        el.classList.toggle("latched", controls.includes(el.dataset.key)));
+}
+
+// Nothing is held while the device is unplugged
+function clearPressed() {
+    document.querySelectorAll('.pressed').forEach(el => el.classList.remove("pressed"));
+    axisX = 0;
+    axisY = 0;
+    stickDir = [0, 0];
+    stickSector = { sector: -1, ring: false };
+    const dot = document.getElementById("joystick-dot");
+    if (dot) dot.setAttribute("hidden", "hidden");
+}
 
 function handleButton(data) {
     const key = `[data-key="button-${data.index}"]`;
@@ -121,36 +277,23 @@ function handleAxis(data) {
             break;
     }
 
-    const key = `[data-key^="axis-${data.index}-"]`;
+    if (typeof stick === 'undefined') return;
 
-    const els = document.querySelectorAll(key);
-    if (!els || els.length == 0) return;
+    if (stick.mode === 'sectors') {
+        stickSector = stickSectors();
+        document.querySelectorAll('[data-key^="sector-"]').forEach(el => {
+            const key = el.dataset.key;
+            el.classList.toggle("pressed", key === 'sector-ring' ? stickSector.ring : key === `sector-${stickSector.sector}`);
+        });
+    }
 
-    els.forEach(function (el) {
-        if (
-            axisX >= deadzone &&
-            el.getAttribute("data-key").endsWith("0-positive")
-        ) {
-            el.classList.add("pressed");
-        } else if (
-            axisY >= deadzone &&
-            el.getAttribute("data-key").endsWith("1-positive")
-        ) {
-            el.classList.add("pressed");
-        } else if (
-            axisX <= -deadzone &&
-            el.getAttribute("data-key").endsWith("0-negative")
-        ) {
-            el.classList.add("pressed");
-        } else if (
-            axisY <= -deadzone &&
-            el.getAttribute("data-key").endsWith("1-negative")
-        ) {
-            el.classList.add("pressed");
-        } else {
-            el.classList.remove("pressed");
-        }
-    })
+    stickDir = stickDirections();
+    stickDir.forEach((dir, index) => {
+        document.querySelectorAll(`[data-key="axis-${index}-positive"]`)
+            .forEach(el => el.classList.toggle("pressed", dir > 0));
+        document.querySelectorAll(`[data-key="axis-${index}-negative"]`)
+            .forEach(el => el.classList.toggle("pressed", dir < 0));
+    });
 
     const dot = document.getElementById("joystick-dot")
 
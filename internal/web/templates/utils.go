@@ -1,21 +1,287 @@
 package templates
 
 import (
+	"cmp"
+	"encoding/json"
+	"math"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/caedis/noreza/internal/mapping"
 )
 
+// An entry in the sidebar's device picker
+type DeviceOption struct {
+	Identifier string
+	Name       string
+}
+
+// Last few characters of a device identifier, enough to tell devices apart
+func shortIdentifier(identifier string) string {
+	if len(identifier) <= 4 {
+		return identifier
+	}
+	return "..." + identifier[len(identifier)-4:]
+}
+
+// Mouse outputs offered in the editor, in display order
+var mouseButtons = []struct {
+	code  string
+	label string
+}{
+	{"LClick", "Left Click"},
+	{"MClick", "Middle Click"},
+	{"RClick", "Right Click"},
+	{"Back", "Back"},
+	{"Forward", "Forward"},
+	{"WheelUp", "Wheel Up"},
+	{"WheelDown", "Wheel Down"},
+	{"WheelLeft", "Wheel Left"},
+	{"WheelRight", "Wheel Right"},
+}
+
+// Gamepad outputs offered in the editor, in display order
+var gamepadButtons = []struct {
+	code  string
+	label string
+}{
+	{"PadSouth", "A"},
+	{"PadEast", "B"},
+	{"PadWest", "X"},
+	{"PadNorth", "Y"},
+	{"PadLB", "LB"},
+	{"PadRB", "RB"},
+	{"PadLT", "LT"},
+	{"PadRT", "RT"},
+	{"PadL3", "L3"},
+	{"PadR3", "R3"},
+	{"PadSelect", "Select"},
+	{"PadStart", "Start"},
+	{"PadHome", "Home"},
+	{"PadUp", "D-Up"},
+	{"PadDown", "D-Down"},
+	{"PadLeft", "D-Left"},
+	{"PadRight", "D-Right"},
+}
+
+func keyLabel(code int) string {
+	if label, ok := mapping.CodeToKeyFriendly[code]; ok {
+		return label
+	}
+	return mapping.KeyName(code)
+}
+
+type keyOption struct {
+	name  string
+	label string
+}
+
+// Every named key for the editor's key picker, sorted by label
+var keyOptions = func() []keyOption {
+	options := make([]keyOption, 0, len(mapping.KeyToCode))
+	for name, code := range mapping.KeyToCode {
+		options = append(options, keyOption{name, keyLabel(code)})
+	}
+	slices.SortFunc(options, func(a, b keyOption) int {
+		return cmp.Compare(a.label, b.label)
+	})
+	return options
+}()
+
+// A chord's buttons numbered the way the editor shows them, like "#5 + #6"
+func chordLabel(buttons []uint8) string {
+	names := make([]string, 0, len(buttons))
+	for _, b := range slices.Sorted(slices.Values(buttons)) {
+		names = append(names, "#"+strconv.Itoa(int(b)+1))
+	}
+	return strings.Join(names, " + ")
+}
+
 func concatKeys(keys []mapping.KeyMapping) string {
 	var keyVals []string
 
 	for _, v := range keys {
-		if v.Mode == mapping.Mouse {
+		switch v.Mode {
+		case mapping.Mouse:
 			keyVals = append(keyVals, mapping.CodeToMouse[v.Code])
-		} else {
-			keyVals = append(keyVals, mapping.CodeToKeyFriendly[v.Code])
+		case mapping.Gamepad:
+			keyVals = append(keyVals, mapping.CodeToGamepad[v.Code])
+		default:
+			keyVals = append(keyVals, keyLabel(v.Code))
 		}
 	}
 
 	return strings.Join(keyVals, "\n")
 }
+
+func bindingLabel(b mapping.Binding) string {
+	label := concatKeys(b.Keys)
+	if b.Hold != nil && len(b.Hold.Keys) > 0 {
+		label += "\nHold: " + concatKeys(b.Hold.Keys)
+	}
+	if b.Macro != nil && len(b.Macro.Steps) > 0 {
+		label += "\nMacro"
+	}
+	if b.Turbo != nil {
+		label += "\nTurbo"
+	}
+	switch b.Latch {
+	case mapping.LatchToggle:
+		label += "\nToggle"
+	case mapping.LatchOneShot:
+		label += "\nOne-shot"
+	}
+	return label
+}
+
+// A profile's auto switch rules as JSON for the settings modal
+func rulesJSON(m mapping.Mapping) string {
+	rules := m.Rules()
+	if rules == nil {
+		rules = []mapping.WindowRule{}
+	}
+	data, _ := json.Marshal(rules)
+	return string(data)
+}
+
+// Index of the rule that decides the profile for win, or -1
+func winningRule(rules []mapping.WindowProfile, win *mapping.WindowInfo) int {
+	if win == nil {
+		return -1
+	}
+	for i := range rules {
+		if rules[i].Matches(*win) {
+			return i
+		}
+	}
+	return -1
+}
+
+func ruleConditions(rule mapping.WindowProfile) string {
+	var conditions []string
+	for _, c := range []struct {
+		label string
+		regex *regexp.Regexp
+	}{
+		{"Title", rule.NameRegex},
+		{"Class", rule.ClassRegex},
+		{"Process", rule.ProcessRegex},
+		{"Steam App", rule.SteamAppRegex},
+	} {
+		if c.regex != nil {
+			conditions = append(conditions, c.label+": "+c.regex.String())
+		}
+	}
+	return strings.Join(conditions, "\n")
+}
+
+// Link to download a single profile as a bundle
+func exportURL(profile string) string {
+	return "/profiles/export?profile=" + url.QueryEscape(profile)
+}
+
+// Deadzone settings for the joystick visualisation in init.js
+type stickConfig struct {
+	Shape        mapping.DeadzoneShape `json:"shape"`
+	DefaultShape mapping.DeadzoneShape `json:"default_shape"`
+	InnerX       int16                 `json:"inner_x"`
+	InnerY       int16                 `json:"inner_y"`
+	OuterX       int16                 `json:"outer_x"`
+	OuterY       int16                 `json:"outer_y"`
+	Hysteresis   int16                 `json:"hysteresis"`
+	Mode         mapping.JoystickMode  `json:"mode"`
+	Sectors      int                   `json:"sectors"`
+	RingAt       int16                 `json:"ring_at"`
+}
+
+func newStickConfig(m mapping.Mapping) stickConfig {
+	cfg := stickConfig{
+		Shape:        m.DeadzoneShape,
+		DefaultShape: mapping.DeadzoneAxial,
+		InnerX:       m.AxisDeadzones[mapping.StickXAxis].Inner,
+		InnerY:       m.AxisDeadzones[mapping.StickYAxis].Inner,
+		OuterX:       m.AxisDeadzones[mapping.StickXAxis].Outer,
+		OuterY:       m.AxisDeadzones[mapping.StickYAxis].Outer,
+		Hysteresis:   m.DeadzoneHysteresis,
+		Mode:         m.JoystickMode,
+		Sectors:      m.NumSectors(),
+		RingAt:       m.RingThreshold,
+	}
+	if m.JoystickMode == mapping.JoystickGamepad {
+		cfg.DefaultShape = mapping.DeadzoneRadial
+	}
+	return cfg
+}
+
+// Radii of the editor's sector wheel, in pixels from the stick's centre
+const (
+	wheelInner     = 40
+	wheelOuter     = 100
+	wheelRingInner = 104
+	wheelRingOuter = 122
+)
+
+// One slice of the editor's sector wheel
+type sectorWedge struct {
+	Index  uint8
+	Path   string
+	LabelX string
+	LabelY string
+}
+
+// Point r pixels from the centre, clockwise from straight up
+func wheelPoint(angle, r float64) (string, string) {
+	return strconv.FormatFloat(r*math.Sin(angle), 'f', 2, 64), strconv.FormatFloat(-r*math.Cos(angle), 'f', 2, 64)
+}
+
+// SVG path of the band between radii inner and outer, from angle from to to
+func bandPath(from, to, inner, outer float64) string {
+	if to-from >= 2*math.Pi {
+		// arcs can't start and end on the same point, so go round in halves
+		mid := from + math.Pi
+		return bandPath(from, mid, inner, outer) + " " + bandPath(mid, to, inner, outer)
+	}
+	large := "0"
+	if to-from > math.Pi {
+		large = "1"
+	}
+	r := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	point := func(angle, radius float64) string {
+		x, y := wheelPoint(angle, radius)
+		return x + " " + y
+	}
+	return "M " + point(from, inner) +
+		" L " + point(from, outer) +
+		" A " + r(outer) + " " + r(outer) + " 0 " + large + " 1 " + point(to, outer) +
+		" L " + point(to, inner) +
+		" A " + r(inner) + " " + r(inner) + " 0 " + large + " 0 " + point(from, inner) + " Z"
+}
+
+func sectorWedges(count int) []sectorWedge {
+	width := 2 * math.Pi / float64(count)
+	wedges := make([]sectorWedge, count)
+	for i := range wedges {
+		centre := float64(i) * width
+		x, y := wheelPoint(centre, (wheelInner+wheelOuter)/2)
+		wedges[i] = sectorWedge{
+			Index:  uint8(i),
+			Path:   bandPath(centre-width/2, centre+width/2, wheelInner, wheelOuter),
+			LabelX: x,
+			LabelY: y,
+		}
+	}
+	return wedges
+}
+
+func ringPath() string { return bandPath(0, 2*math.Pi, wheelRingInner, wheelRingOuter) }
+
+// Blank for zero so the input falls back to its placeholder default
+func formatFloat(v float64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}